@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Shared by every client so --print-access-summary covers all clusters
+var apiAccess = &accessRecorder{seen: map[accessKey]map[string]bool{}}
+
+type accessKey struct {
+	verb     string
+	group    string
+	resource string
+	// False for cluster-scoped requests
+	namespaced bool
+	// Set for requests outside the resource API, e.g. discovery
+	nonResource string
+}
+
+type accessRecorder struct {
+	mu sync.Mutex
+	// Namespaces each access was made in
+	seen map[accessKey]map[string]bool
+}
+
+func (a *accessRecorder) wrap(rt http.RoundTripper) http.RoundTripper {
+	return &accessTransport{next: rt, recorder: a}
+}
+
+type accessTransport struct {
+	next     http.RoundTripper
+	recorder *accessRecorder
+}
+
+func (t *accessTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, ns := requestAccess(req)
+	t.recorder.mu.Lock()
+	if t.recorder.seen[key] == nil {
+		t.recorder.seen[key] = map[string]bool{}
+	}
+	if ns != "" {
+		t.recorder.seen[key][ns] = true
+	}
+	t.recorder.mu.Unlock()
+
+	return t.next.RoundTrip(req)
+}
+
+// Maps a request to the RBAC verb and resource the API server authorizes it as
+func requestAccess(req *http.Request) (accessKey, string) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	var group string
+	switch {
+	case len(parts) >= 3 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 4 && parts[0] == "apis":
+		group, parts = parts[1], parts[3:]
+	default:
+		return accessKey{verb: strings.ToLower(req.Method), nonResource: req.URL.Path}, ""
+	}
+
+	ns := ""
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		ns, parts = parts[1], parts[2:]
+	}
+	resource, name := parts[0], ""
+	if len(parts) >= 2 {
+		name = parts[1]
+	}
+	if len(parts) >= 3 {
+		resource += "/" + parts[2]
+	}
+
+	verb := ""
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		verb = "get"
+		if name == "" {
+			verb = "list"
+			if req.URL.Query().Get("watch") == "true" {
+				verb = "watch"
+			}
+		}
+	case http.MethodPost:
+		verb = "create"
+	case http.MethodPut:
+		verb = "update"
+	case http.MethodPatch:
+		verb = "patch"
+	case http.MethodDelete:
+		verb = "delete"
+		if name == "" {
+			verb = "deletecollection"
+		}
+	default:
+		verb = strings.ToLower(req.Method)
+	}
+
+	return accessKey{verb: verb, group: group, resource: resource, namespaced: ns != ""}, ns
+}
+
+func (a *accessRecorder) keys() []accessKey {
+	var keys []accessKey
+	for key := range a.seen {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		x, y := keys[i], keys[j]
+		if x.nonResource != y.nonResource {
+			return x.nonResource < y.nonResource
+		}
+		if x.group != y.group {
+			return x.group < y.group
+		}
+		if x.resource != y.resource {
+			return x.resource < y.resource
+		}
+		if x.namespaced != y.namespaced {
+			return !x.namespaced
+		}
+		return x.verb < y.verb
+	})
+
+	return keys
+}
+
+func (a *accessRecorder) report(w io.Writer) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	fmt.Fprintln(w, "API access:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERB\tGROUP\tRESOURCE\tSCOPE")
+	for _, key := range a.keys() {
+		if key.nonResource != "" {
+			fmt.Fprintf(tw, "%s\t-\t%s\tnon-resource\n", key.verb, key.nonResource)
+			continue
+		}
+		group := key.group
+		if group == "" {
+			group = "core"
+		}
+		scope := "cluster"
+		if key.namespaced {
+			scope = fmt.Sprintf("namespaced (%d namespaces)", len(a.seen[key]))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key.verb, group, key.resource, scope)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(a.role())
+	if err != nil {
+		return fmt.Errorf("unable to encode the role: %v", err)
+	}
+	fmt.Fprintf(w, "---\n%s", data)
+
+	return nil
+}
+
+// Smallest role granting what was used: a Role when everything stayed in one
+// namespace, a ClusterRole otherwise
+func (a *accessRecorder) role() interface{} {
+	namespaces := map[string]bool{}
+	clusterScoped := false
+	for key, seen := range a.seen {
+		if !key.namespaced {
+			clusterScoped = true
+		}
+		for ns := range seen {
+			namespaces[ns] = true
+		}
+	}
+
+	// One rule per resource, discovery paths together
+	var rules []rbacv1.PolicyRule
+	byResource := map[string]*rbacv1.PolicyRule{}
+	var nonResource *rbacv1.PolicyRule
+	for _, key := range a.keys() {
+		if key.nonResource != "" {
+			if nonResource == nil {
+				nonResource = &rbacv1.PolicyRule{}
+			}
+			nonResource.NonResourceURLs = appendMissing(nonResource.NonResourceURLs, key.nonResource)
+			nonResource.Verbs = appendMissing(nonResource.Verbs, key.verb)
+			continue
+		}
+		id := key.group + "/" + key.resource
+		rule, ok := byResource[id]
+		if !ok {
+			rule = &rbacv1.PolicyRule{APIGroups: []string{key.group}, Resources: []string{key.resource}}
+			byResource[id] = rule
+		}
+		rule.Verbs = appendMissing(rule.Verbs, key.verb)
+	}
+	for _, key := range a.keys() {
+		if rule, ok := byResource[key.group+"/"+key.resource]; ok {
+			rules = append(rules, *rule)
+			delete(byResource, key.group+"/"+key.resource)
+		}
+	}
+
+	if !clusterScoped && nonResource == nil && len(namespaces) == 1 {
+		role := rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+			ObjectMeta: metav1.ObjectMeta{Name: "check-secrets"},
+			Rules:      rules,
+		}
+		for ns := range namespaces {
+			role.Namespace = ns
+		}
+		return role
+	}
+
+	if nonResource != nil {
+		rules = append(rules, *nonResource)
+	}
+	return rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: "check-secrets"},
+		Rules:      rules,
+	}
+}
+
+func appendMissing(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+
+	return append(values, value)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/rest"
+)
+
+func TestRequestAccess(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		want   accessKey
+		wantNS string
+	}{
+		{"GET", "/api/v1/namespaces/apps/secrets/cert", accessKey{verb: "get", resource: "secrets", namespaced: true}, "apps"},
+		{"GET", "/api/v1/namespaces/apps/secrets?labelSelector=a", accessKey{verb: "list", resource: "secrets", namespaced: true}, "apps"},
+		{"GET", "/api/v1/secrets", accessKey{verb: "list", resource: "secrets"}, ""},
+		{"GET", "/api/v1/namespaces/apps", accessKey{verb: "get", resource: "namespaces"}, ""},
+		{"GET", "/api/v1/namespaces", accessKey{verb: "list", resource: "namespaces"}, ""},
+		{"GET", "/api/v1/namespaces/apps/pods?watch=true", accessKey{verb: "watch", resource: "pods", namespaced: true}, "apps"},
+		{"GET", "/api/v1/namespaces/apps/pods/http:web:15000/proxy/certs", accessKey{verb: "get", resource: "pods/proxy", namespaced: true}, "apps"},
+		{"GET", "/apis/networking.istio.io/v1/namespaces/apps/gateways", accessKey{verb: "list", group: "networking.istio.io", resource: "gateways", namespaced: true}, "apps"},
+		{"GET", "/apis/networking.istio.io/v1", accessKey{verb: "get", nonResource: "/apis/networking.istio.io/v1"}, ""},
+		{"GET", "/api", accessKey{verb: "get", nonResource: "/api"}, ""},
+		{"HEAD", "/api/v1/namespaces/apps/secrets/cert", accessKey{verb: "get", resource: "secrets", namespaced: true}, "apps"},
+		{"POST", "/api/v1/namespaces/apps/configmaps", accessKey{verb: "create", resource: "configmaps", namespaced: true}, "apps"},
+		{"PUT", "/api/v1/namespaces/apps/configmaps/state", accessKey{verb: "update", resource: "configmaps", namespaced: true}, "apps"},
+		{"PATCH", "/api/v1/namespaces/apps/configmaps/state", accessKey{verb: "patch", resource: "configmaps", namespaced: true}, "apps"},
+		{"DELETE", "/api/v1/namespaces/apps/configmaps/state", accessKey{verb: "delete", resource: "configmaps", namespaced: true}, "apps"},
+		{"DELETE", "/api/v1/namespaces/apps/configmaps", accessKey{verb: "deletecollection", resource: "configmaps", namespaced: true}, "apps"},
+		{"POST", "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews", accessKey{verb: "create", group: "authorization.k8s.io", resource: "selfsubjectaccessreviews"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			key, ns := requestAccess(httptest.NewRequest(tt.method, tt.target, nil))
+			if key != tt.want || ns != tt.wantNS {
+				t.Errorf("requestAccess() = %+v, %q, want %+v, %q", key, ns, tt.want, tt.wantNS)
+			}
+		})
+	}
+}
+
+func TestAccessKnownScan(t *testing.T) {
+	defer func(enabled bool, recorder *accessRecorder) {
+		*printAccessSummary, apiAccess = enabled, recorder
+	}(*printAccessSummary, apiAccess)
+	*noRecheck = true
+	defer func() { *noRecheck = false }()
+
+	cases := selfTestCases(time.Now())
+	secrets := map[string]*corev1.Secret{}
+	for _, tc := range cases {
+		if tc.build == nil {
+			continue
+		}
+		secret, err := tc.build()
+		if err != nil {
+			t.Fatalf("building %s: %v", tc.name, err)
+		}
+		secrets[tc.secret] = secret
+	}
+	srv := selfTestServer(cases, secrets)
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		sources  map[string]bool
+		want     []string
+		wantKind string
+	}{
+		{
+			name:    "istio gateways",
+			sources: map[string]bool{sourceIstio: true},
+			want: []string{
+				"get core namespaces cluster",
+				"get core secrets namespaced",
+				"list networking.istio.io gateways namespaced",
+				"get /apis/networking.istio.io/v1",
+				"get /apis/networking.istio.io/v1alpha3",
+				"get /apis/networking.istio.io/v1beta1",
+			},
+			wantKind: "ClusterRole",
+		},
+		{
+			name:    "ingresses and gateway api without the crds",
+			sources: map[string]bool{sourceIngress: true, sourceGatewayAPI: true},
+			want: []string{
+				"list gateway.networking.k8s.io gateways namespaced",
+				"list networking.k8s.io ingresses namespaced",
+				"get /apis/gateway.networking.k8s.io/v1",
+				"get /apis/gateway.networking.k8s.io/v1beta1",
+			},
+			wantKind: "ClusterRole",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*printAccessSummary = true
+			apiAccess = &accessRecorder{seen: map[accessKey]map[string]bool{}}
+			kclient, dclient, err := newClients(&rest.Config{Host: srv.URL, QPS: -1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			scan := &scanContext{buffered: true, drift: newDriftTracker(nil), sources: tt.sources}
+			scan.scanNamespace(kclient, dclient, selfTestNamespace, newAbsentGroups(scan.cluster, nil))
+
+			var got []string
+			for _, key := range apiAccess.keys() {
+				switch {
+				case key.nonResource != "":
+					got = append(got, key.verb+" "+key.nonResource)
+				default:
+					group, scope := key.group, "cluster"
+					if group == "" {
+						group = "core"
+					}
+					if key.namespaced {
+						scope = "namespaced"
+						if ns := apiAccess.seen[key]; len(ns) != 1 || !ns[selfTestNamespace] {
+							t.Errorf("%s %s used in namespaces %v, want only %s", key.verb, key.resource, ns, selfTestNamespace)
+						}
+					}
+					got = append(got, key.verb+" "+group+" "+key.resource+" "+scope)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("access set = %q, want %q", got, tt.want)
+			}
+
+			var kind string
+			switch role := apiAccess.role().(type) {
+			case rbacv1.Role:
+				kind = role.Kind
+			case rbacv1.ClusterRole:
+				kind = role.Kind
+			}
+			if kind != tt.wantKind {
+				t.Errorf("role kind = %s, want %s", kind, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestAccessRole(t *testing.T) {
+	tests := []struct {
+		name      string
+		seen      map[accessKey]map[string]bool
+		wantKind  string
+		wantNS    string
+		wantRules int
+	}{
+		{
+			name: "one namespace",
+			seen: map[accessKey]map[string]bool{
+				{verb: "get", resource: "secrets", namespaced: true}:                                 {"apps": true},
+				{verb: "list", resource: "secrets", namespaced: true}:                                {"apps": true},
+				{verb: "list", group: "networking.istio.io", resource: "gateways", namespaced: true}: {"apps": true},
+			},
+			wantKind:  "Role",
+			wantNS:    "apps",
+			wantRules: 2,
+		},
+		{
+			name: "several namespaces",
+			seen: map[accessKey]map[string]bool{
+				{verb: "get", resource: "secrets", namespaced: true}: {"apps": true, "web": true},
+			},
+			wantKind:  "ClusterRole",
+			wantRules: 1,
+		},
+		{
+			name: "cluster scoped",
+			seen: map[accessKey]map[string]bool{
+				{verb: "get", resource: "secrets", namespaced: true}: {"apps": true},
+				{verb: "list", resource: "namespaces"}:               {},
+			},
+			wantKind:  "ClusterRole",
+			wantRules: 2,
+		},
+		{
+			name: "discovery",
+			seen: map[accessKey]map[string]bool{
+				{verb: "get", resource: "secrets", namespaced: true}: {"apps": true},
+				{verb: "get", nonResource: "/api"}:                   {},
+				{verb: "get", nonResource: "/apis"}:                  {},
+			},
+			wantKind:  "ClusterRole",
+			wantRules: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &accessRecorder{seen: tt.seen}
+			var kind, ns string
+			var rules []rbacv1.PolicyRule
+			switch role := recorder.role().(type) {
+			case rbacv1.Role:
+				kind, ns, rules = role.Kind, role.Namespace, role.Rules
+			case rbacv1.ClusterRole:
+				kind, rules = role.Kind, role.Rules
+			}
+			if kind != tt.wantKind || ns != tt.wantNS || len(rules) != tt.wantRules {
+				t.Errorf("role() = %s in %q with %d rules, want %s in %q with %d rules", kind, ns, len(rules), tt.wantKind, tt.wantNS, tt.wantRules)
+			}
+		})
+	}
+}
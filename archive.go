@@ -0,0 +1,205 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	rdebug "runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+type archiveMetadata struct {
+	ToolVersion string    `json:"toolVersion"`
+	Clusters    []string  `json:"clusters"`
+	ScanTime    time.Time `json:"scanTime"`
+	ConfigHash  string    `json:"configHash"`
+}
+
+// Evidence is written as the scan goes, only the finding manifest is kept in memory
+type reportArchive struct {
+	mu       sync.Mutex
+	file     *os.File
+	gz       *gzip.Writer
+	tw       *tar.Writer
+	files    map[string]bool
+	findings map[string][]string
+	clusters map[string]bool
+	started  time.Time
+
+	// First write error, returned by close
+	err error
+}
+
+func newReportArchive(file string) (*reportArchive, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create archive: %v", err)
+	}
+	gz := gzip.NewWriter(f)
+
+	return &reportArchive{
+		file:     f,
+		gz:       gz,
+		tw:       tar.NewWriter(gz),
+		files:    map[string]bool{},
+		findings: map[string][]string{},
+		clusters: map[string]bool{},
+		started:  time.Now(),
+	}, nil
+}
+
+// With dryRun only says where it would write, no archive means no evidence is collected
+func openReportArchive(file string, dryRun bool) (*reportArchive, error) {
+	if dryRun {
+		fmt.Fprintf(os.Stderr, "dry-run: would write archive %s\n", file)
+		return nil, nil
+	}
+
+	return newReportArchive(file)
+}
+
+func (a *reportArchive) add(name string, data []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.err != nil || a.files[name] {
+		return
+	}
+	a.files[name] = true
+
+	err := a.tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: a.started, Typeflag: tar.TypeReg})
+	if err == nil {
+		_, err = a.tw.Write(data)
+	}
+	if err != nil {
+		a.err = fmt.Errorf("unable to write %s to archive: %v", name, err)
+	}
+}
+
+// Cluster names may be ARNs or URLs, keep them to one path element
+func archivePath(elems ...string) string {
+	for i, elem := range elems {
+		elems[i] = strings.NewReplacer("/", "_", ":", "_").Replace(elem)
+	}
+
+	return path.Join(elems...)
+}
+
+func certificateEvidence(cluster, ns, secret string) string {
+	return archivePath("certificates", cluster, ns, secret) + ".pem"
+}
+
+func objectEvidence(cluster, ns string, by referrer) string {
+	return archivePath("objects", cluster, ns, by.Kind, by.Name) + ".yaml"
+}
+
+// Only the re-encoded certificates, never anything else the secret holds
+func (a *reportArchive) addCertificate(cluster string, secret corev1.Secret) {
+	chain, err := certs.SecretCertificates(secret)
+	if err != nil {
+		return
+	}
+
+	var data []byte
+	for _, cert := range chain {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	a.add(certificateEvidence(cluster, secret.Namespace, secret.Name), data)
+}
+
+func (a *reportArchive) addObject(cluster, ns string, by referrer, obj interface{}) {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		debugf("Unable to encode %s in namespace %s for the archive: %v", by, ns, err)
+		return
+	}
+	a.add(objectEvidence(cluster, ns, by), data)
+}
+
+// Links a finding to the evidence already written for it
+func (a *reportArchive) link(r result) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.clusters[r.Cluster] = true
+	var files []string
+	secretNs := r.Namespace
+	if r.SecretNamespace != "" {
+		secretNs = r.SecretNamespace
+	}
+	if file := certificateEvidence(r.Cluster, secretNs, r.Secret); r.Secret != "" && a.files[file] {
+		files = append(files, file)
+	}
+	for _, by := range r.ReferencedBy {
+		if file := objectEvidence(r.Cluster, r.Namespace, by); a.files[file] {
+			files = append(files, file)
+		}
+	}
+	a.findings[r.ID] = append(a.findings[r.ID], files...)
+}
+
+// Hash of every flag set on the command line, so two archives can be told apart
+func configHash() string {
+	var set []string
+	flag.Visit(func(f *flag.Flag) {
+		set = append(set, f.Name+"="+f.Value.String())
+	})
+	sort.Strings(set)
+	sum := sha256.Sum256([]byte(strings.Join(set, "\n")))
+
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+func (a *reportArchive) close(r report) error {
+	a.mu.Lock()
+	metadata := archiveMetadata{ToolVersion: "unknown", ScanTime: a.started, ConfigHash: configHash()}
+	for cluster := range a.clusters {
+		metadata.Clusters = append(metadata.Clusters, cluster)
+	}
+	sort.Strings(metadata.Clusters)
+	findings := a.findings
+	a.mu.Unlock()
+
+	if info, ok := rdebug.ReadBuildInfo(); ok {
+		metadata.ToolVersion = info.Main.Version
+	}
+
+	for _, entry := range []struct {
+		name  string
+		value interface{}
+	}{{"metadata.json", metadata}, {"report.json", r}, {"manifest.json", findings}} {
+		data, err := json.MarshalIndent(entry.value, "", "  ")
+		if err != nil {
+			return fmt.Errorf("unable to encode %s: %v", entry.name, err)
+		}
+		a.add(entry.name, append(data, '\n'))
+	}
+
+	if a.err != nil {
+		a.file.Close()
+		return a.err
+	}
+	err := a.tw.Close()
+	if err == nil {
+		err = a.gz.Close()
+	}
+	if err != nil {
+		a.file.Close()
+		return fmt.Errorf("unable to finish archive: %v", err)
+	}
+
+	return a.file.Close()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenReportArchiveDryRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		dryRun bool
+	}{
+		{name: "dry run", dryRun: true},
+		{name: "written", dryRun: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "evidence.tar.gz")
+			archive, err := openReportArchive(file, tt.dryRun)
+			if err != nil {
+				t.Fatalf("openReportArchive() error = %v", err)
+			}
+			if archive != nil {
+				if err := archive.close(report{}); err != nil {
+					t.Fatalf("close() error = %v", err)
+				}
+			}
+
+			_, err = os.Stat(file)
+			if tt.dryRun && (archive != nil || !os.IsNotExist(err)) {
+				t.Errorf("dry run opened %v and created the archive (stat error %v)", archive, err)
+			}
+			if !tt.dryRun && err != nil {
+				t.Errorf("archive not written: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Finding IDs accepted from a previous JSON report
+func loadBaseline(file string) (map[string]bool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read baseline: %v", err)
+	}
+
+	var previous report
+	err = json.Unmarshal(data, &previous)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse baseline: %v", err)
+	}
+
+	ids := map[string]bool{}
+	for i, r := range previous.Results {
+		if r.ID == "" {
+			return nil, fmt.Errorf("result %d in baseline has no id, regenerate it with baseline update", i)
+		}
+		ids[r.ID] = true
+	}
+
+	return ids, nil
+}
+
+func writeBaseline(file string, r report) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("unable to create baseline: %v", err)
+	}
+
+	err = renderJSON(f, r)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write baseline: %v", err)
+	}
+
+	return f.Close()
+}
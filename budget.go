@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// Shared by every client so --max-api-requests covers all clusters
+var apiBudget requestBudget
+
+var errBudgetExhausted = errors.New("API request budget nearly exhausted")
+
+type requestBudget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+func (b *requestBudget) remaining() int64 {
+	return b.limit - b.used.Load()
+}
+
+func (b *requestBudget) wrap(rt http.RoundTripper) http.RoundTripper {
+	return &budgetTransport{next: rt, budget: b}
+}
+
+type budgetTransport struct {
+	next   http.RoundTripper
+	budget *requestBudget
+}
+
+// Only counts: the budget stops new namespaces, the ones started are let finish
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.budget.used.Add(1)
+
+	return t.next.RoundTrip(req)
+}
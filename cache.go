@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Bump when the cached layout changes, older entries become misses
+const cacheVersion = 1
+
+type namespaceCache struct {
+	Version    int       `json:"version"`
+	Server     string    `json:"server"`
+	Fetched    time.Time `json:"fetched"`
+	Namespaces []string  `json:"namespaces"`
+}
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "check-secrets")
+}
+
+func cacheFile(dir, server string) string {
+	return filepath.Join(dir, fmt.Sprintf("namespaces-%x.json", sha256.Sum256([]byte(server))))
+}
+
+func readNamespaceCache(dir, server string, ttl time.Duration) ([]string, bool) {
+	data, err := os.ReadFile(cacheFile(dir, server))
+	if err != nil {
+		return nil, false
+	}
+
+	// Anything unexpected is a miss, never an error
+	var entry namespaceCache
+	err = json.Unmarshal(data, &entry)
+	if err != nil || entry.Version != cacheVersion || entry.Server != server || time.Since(entry.Fetched) > ttl {
+		return nil, false
+	}
+
+	debugf("Using namespace list cached at %s for %s", entry.Fetched.Format(time.RFC3339), server)
+	return entry.Namespaces, true
+}
+
+// API groups found missing on the last run, skipped without a request until the TTL expires
+type groupCache struct {
+	Version int       `json:"version"`
+	Server  string    `json:"server"`
+	Fetched time.Time `json:"fetched"`
+	Absent  []string  `json:"absent"`
+}
+
+func groupCacheFile(dir, server string) string {
+	return filepath.Join(dir, fmt.Sprintf("groups-%x.json", sha256.Sum256([]byte(server))))
+}
+
+func readGroupCache(dir, server string, ttl time.Duration) []string {
+	data, err := os.ReadFile(groupCacheFile(dir, server))
+	if err != nil {
+		return nil
+	}
+
+	var entry groupCache
+	err = json.Unmarshal(data, &entry)
+	if err != nil || entry.Version != cacheVersion || entry.Server != server || time.Since(entry.Fetched) > ttl {
+		return nil
+	}
+
+	return entry.Absent
+}
+
+func writeGroupCache(dir, server string, absent []string) {
+	data, err := json.Marshal(groupCache{
+		Version: cacheVersion,
+		Server:  server,
+		Fetched: time.Now(),
+		Absent:  absent,
+	})
+	if err != nil {
+		return
+	}
+
+	err = os.MkdirAll(dir, 0o700)
+	if err == nil {
+		err = os.WriteFile(groupCacheFile(dir, server), data, 0o600)
+	}
+	if err != nil {
+		debugf("Unable to write the API group cache: %v", err)
+	}
+}
+
+func writeNamespaceCache(dir, server string, namespaces []string) {
+	data, err := json.Marshal(namespaceCache{
+		Version:    cacheVersion,
+		Server:     server,
+		Fetched:    time.Now(),
+		Namespaces: namespaces,
+	})
+	if err != nil {
+		return
+	}
+
+	err = os.MkdirAll(dir, 0o700)
+	if err == nil {
+		err = os.WriteFile(cacheFile(dir, server), data, 0o600)
+	}
+	if err != nil {
+		debugf("Unable to write the namespace cache: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ArnauSB/check-secrets/certs"
+)
+
+func hostCovered(host string, cert *x509.Certificate) bool {
+	// Malformed hosts never match traffic, so they are never covered
+	_, host, err := parseGatewayHost(host)
+	if err != nil {
+		return false
+	}
+
+	return certs.HostCovered(host, cert)
+}
+
+func printChain(w io.Writer, chain []*x509.Certificate, indent string) {
+	for i, cert := range chain {
+		role := "intermediate"
+		if i == 0 {
+			role = "leaf"
+		}
+
+		fmt.Fprintf(w, "%sCertificate %d (%s)\n", indent, i, role)
+		fmt.Fprintf(w, "%s  Subject:   %s\n", indent, cert.Subject.String())
+		fmt.Fprintf(w, "%s  Issuer:    %s\n", indent, cert.Issuer.String())
+		fmt.Fprintf(w, "%s  Serial:    %s\n", indent, cert.SerialNumber.Text(16))
+		fmt.Fprintf(w, "%s  NotBefore: %s\n", indent, cert.NotBefore.UTC().Format(opensslTimeFormat))
+		fmt.Fprintf(w, "%s  NotAfter:  %s\n", indent, cert.NotAfter.UTC().Format(opensslTimeFormat))
+		if len(cert.DNSNames) > 0 {
+			fmt.Fprintf(w, "%s  SANs:      %s\n", indent, strings.Join(cert.DNSNames, ", "))
+		}
+		fmt.Fprintf(w, "%s  Signature: %s\n", indent, cert.SignatureAlgorithm)
+		fmt.Fprintf(w, "%s  CA:        %t\n", indent, cert.IsCA)
+	}
+}
@@ -0,0 +1,208 @@
+// Package certs analyzes the certificates held in Kubernetes secrets without
+// talking to the API server, so callers that already have the secrets (for
+// example from an informer cache) can reuse the check-secrets analysis.
+//
+//	finding, err := certs.Evaluate(secret, certs.EvalOptions{WarnDays: 30})
+//	if err != nil {
+//		// the secret holds no usable certificate
+//	}
+//	if finding.Status != certs.StatusOK {
+//		fmt.Println(finding.NotAfter, finding.DaysRemaining)
+//	}
+package certs
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CertificateKeys lists the secret keys looked up for the certificate, in
+// order. Istio also accepts generic secrets holding it under cert.
+var CertificateKeys = []string{"tls.crt", "cert"}
+
+// ParseCertificates decodes every PEM certificate in data, leaf first.
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	// Decode every PEM block, tls.crt usually holds the leaf followed by intermediates
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = rest
+
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block of type %s", block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing certificate: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		// Binary certificates are a common mistake when the secret is built by hand
+		if _, err := x509.ParseCertificate(data); err == nil {
+			return nil, fmt.Errorf("certificate is DER encoded, expected PEM")
+		}
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+
+	return certs, nil
+}
+
+// SecretCertificates parses the certificate chain of a secret, looking it up
+// under CertificateKeys.
+func SecretCertificates(secret corev1.Secret) ([]*x509.Certificate, error) {
+	for _, key := range CertificateKeys {
+		data, ok := secret.Data[key]
+		if !ok {
+			continue
+		}
+
+		certs, err := ParseCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		return certs, nil
+	}
+
+	if _, ok := secret.Data["cacert"]; ok {
+		return nil, fmt.Errorf("only a CA certificate (cacert) found in secret, no tls.crt or cert")
+	}
+	if secret.Type == corev1.SecretTypeTLS {
+		return nil, fmt.Errorf("tls.crt not found in secret")
+	}
+
+	return nil, fmt.Errorf("neither tls.crt nor cert found in %s secret", secret.Type)
+}
+
+// HostCovered reports whether cert is valid for host, a DNS name, a wildcard
+// or an IP address. A bare "*" matches any SNI and is always covered.
+func HostCovered(host string, cert *x509.Certificate) bool {
+	if host == "*" {
+		return true
+	}
+
+	// A wildcard host is only covered by the same wildcard SAN
+	if strings.HasPrefix(host, "*") {
+		for _, name := range cert.DNSNames {
+			if strings.EqualFold(name, host) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// IP hosts match IP SANs, or DNS SANs holding the IP as text as some private CAs emit
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		for _, san := range cert.IPAddresses {
+			if san.Equal(ip) {
+				return true
+			}
+		}
+		for _, name := range cert.DNSNames {
+			if sanIP := net.ParseIP(strings.Trim(name, "[]")); sanIP != nil && sanIP.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return cert.VerifyHostname(host) == nil
+}
+
+// DaysRemaining returns the whole days left from now until notAfter, negative
+// once it has passed.
+func DaysRemaining(notAfter, now time.Time) int {
+	return int(math.Floor(notAfter.Sub(now).Hours() / 24))
+}
+
+// LastModified returns when the secret data was last written, as recorded in
+// its managed fields. Metadata-only writes such as label or owner reference
+// updates are ignored, and the creation time is used when no entry touches the
+// data.
+func LastModified(secret corev1.Secret) time.Time {
+	modified := secret.GetCreationTimestamp().Time
+	for _, entry := range secret.GetManagedFields() {
+		if entry.Time != nil && entry.Time.After(modified) && writesData(entry) {
+			modified = entry.Time.Time
+		}
+	}
+
+	return modified
+}
+
+// writesData reports whether a managed fields entry owns the secret data.
+func writesData(entry metav1.ManagedFieldsEntry) bool {
+	if entry.FieldsV1 == nil {
+		return false
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+		return false
+	}
+	_, data := fields["f:data"]
+	_, stringData := fields["f:stringData"]
+
+	return data || stringData
+}
+
+// LifetimeLeftAtInstall returns the share of the certificate's lifetime still
+// left when it was installed, false when that can't be told.
+func LifetimeLeftAtInstall(cert *x509.Certificate, installed time.Time) (float64, bool) {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	if lifetime <= 0 || installed.Before(cert.NotBefore) {
+		return 0, false
+	}
+
+	return float64(cert.NotAfter.Sub(installed)) / float64(lifetime), true
+}
+
+// CertificateInfo is the reported part of one certificate of a chain.
+type CertificateInfo struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	Serial             string    `json:"serial"`
+	NotBefore          time.Time `json:"notBefore"`
+	NotAfter           time.Time `json:"notAfter"`
+	DNSNames           []string  `json:"dnsNames,omitempty"`
+	IPAddresses        []string  `json:"ipAddresses,omitempty"`
+	SignatureAlgorithm string    `json:"signatureAlgorithm"`
+	IsCA               bool      `json:"isCA"`
+}
+
+// Describe returns the CertificateInfo of every certificate in chain, in order.
+func Describe(chain []*x509.Certificate) []CertificateInfo {
+	var infos []CertificateInfo
+	for _, cert := range chain {
+		info := CertificateInfo{
+			Subject:            cert.Subject.String(),
+			Issuer:             cert.Issuer.String(),
+			Serial:             cert.SerialNumber.Text(16),
+			NotBefore:          cert.NotBefore.UTC(),
+			NotAfter:           cert.NotAfter.UTC(),
+			DNSNames:           cert.DNSNames,
+			SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+			IsCA:               cert.IsCA,
+		}
+		for _, ip := range cert.IPAddresses {
+			info.IPAddresses = append(info.IPAddresses, ip.String())
+		}
+		infos = append(infos, info)
+	}
+
+	return infos
+}
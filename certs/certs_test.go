@@ -0,0 +1,510 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var testNow = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	pem  []byte
+}
+
+// Issues a certificate from template, self-signed when parent is nil
+func issue(t *testing.T, template *x509.Certificate, parent *testCert) testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.SerialNumber == nil {
+		template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	}
+	if template.NotBefore.IsZero() {
+		template.NotBefore = testNow.AddDate(0, -1, 0)
+	}
+	signer, signerCert := crypto.Signer(key), template
+	if parent != nil {
+		signer, signerCert = parent.key, parent.cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testCert{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func issueCA(t *testing.T, name string, notAfter time.Time, parent *testCert) testCert {
+	return issue(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, parent)
+}
+
+func keyPEM(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestParseCertificates(t *testing.T) {
+	root := issueCA(t, "root", testNow.AddDate(5, 0, 0), nil)
+	leaf := issue(t, &x509.Certificate{DNSNames: []string{"example.com"}, NotAfter: testNow.AddDate(0, 3, 0)}, &root)
+	block, _ := pem.Decode(leaf.pem)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    int
+		wantErr string
+	}{
+		{name: "single certificate", data: leaf.pem, want: 1},
+		{name: "chain", data: append(append([]byte{}, leaf.pem...), root.pem...), want: 2},
+		{name: "crlf line endings", data: []byte(strings.ReplaceAll(string(leaf.pem), "\n", "\r\n")), want: 1},
+		{name: "no trailing newline", data: []byte(strings.TrimSuffix(string(leaf.pem), "\n")), want: 1},
+		{name: "der encoded", data: block.Bytes, wantErr: "DER encoded"},
+		{name: "private key block", data: keyPEM(t, leaf.key), wantErr: "unexpected PEM block of type PRIVATE KEY"},
+		{name: "malformed certificate", data: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")}), wantErr: "error parsing certificate"},
+		{name: "empty", data: nil, wantErr: "no PEM certificate found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := ParseCertificates(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseCertificates() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCertificates() error = %v", err)
+			}
+			if len(chain) != tt.want {
+				t.Errorf("ParseCertificates() returned %d certificates, want %d", len(chain), tt.want)
+			}
+			if !chain[0].Equal(leaf.cert) {
+				t.Errorf("ParseCertificates() first certificate is %s, want the leaf", chain[0].Subject)
+			}
+		})
+	}
+}
+
+func TestSecretCertificates(t *testing.T) {
+	leaf := issue(t, &x509.Certificate{DNSNames: []string{"example.com"}, NotAfter: testNow.AddDate(0, 3, 0)}, nil)
+
+	tests := []struct {
+		name    string
+		secret  corev1.Secret
+		wantErr string
+	}{
+		{name: "tls secret", secret: corev1.Secret{Type: corev1.SecretTypeTLS, Data: map[string][]byte{"tls.crt": leaf.pem}}},
+		{name: "generic secret", secret: corev1.Secret{Type: corev1.SecretTypeOpaque, Data: map[string][]byte{"cert": leaf.pem}}},
+		{name: "invalid tls.crt", secret: corev1.Secret{Type: corev1.SecretTypeTLS, Data: map[string][]byte{"tls.crt": []byte("x")}}, wantErr: "tls.crt: no PEM certificate found"},
+		{name: "missing tls.crt", secret: corev1.Secret{Type: corev1.SecretTypeTLS, Data: map[string][]byte{"tls.key": nil}}, wantErr: "tls.crt not found"},
+		{name: "only cacert", secret: corev1.Secret{Type: corev1.SecretTypeOpaque, Data: map[string][]byte{"cacert": leaf.pem}}, wantErr: "only a CA certificate"},
+		{name: "empty generic secret", secret: corev1.Secret{Type: corev1.SecretTypeOpaque}, wantErr: "neither tls.crt nor cert found in Opaque secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := SecretCertificates(tt.secret)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("SecretCertificates() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("SecretCertificates() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHostCovered(t *testing.T) {
+	cert := issue(t, &x509.Certificate{
+		DNSNames:    []string{"example.com", "*.apps.example.com", "10.0.0.9", "[fd00::9]"},
+		IPAddresses: []net.IP{net.ParseIP("192.168.1.10"), net.ParseIP("2001:db8::1")},
+		NotAfter:    testNow.AddDate(0, 3, 0),
+	}, nil).cert
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.com", true},
+		{"www.example.com", false},
+		{"shop.apps.example.com", true},
+		{"a.shop.apps.example.com", false},
+		{"*.apps.example.com", true},
+		{"*.example.com", false},
+		{"*", true},
+		{"192.168.1.10", true},
+		{"192.168.1.11", false},
+		{"2001:db8::1", true},
+		{"2001:0db8:0:0::1", true},
+		{"[2001:db8::1]", true},
+		{"2001:db8::2", false},
+		// Listed as DNS SANs by some private CAs
+		{"10.0.0.9", true},
+		{"fd00::9", true},
+		{"[fd00::9]", true},
+		{"10.0.0.10", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := HostCovered(tt.host, cert); got != tt.want {
+				t.Errorf("HostCovered(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	root := issueCA(t, "root", testNow.AddDate(5, 0, 0), nil)
+	intermediate := issueCA(t, "intermediate", testNow.AddDate(0, 0, 20), &root)
+	leafTemplate := func(notAfter time.Time) *x509.Certificate {
+		return &x509.Certificate{DNSNames: []string{"example.com"}, NotAfter: notAfter}
+	}
+	secret := func(leaf testCert, chain ...testCert) corev1.Secret {
+		data := append([]byte{}, leaf.pem...)
+		for _, cert := range chain {
+			data = append(data, cert.pem...)
+		}
+		return corev1.Secret{Type: corev1.SecretTypeTLS, Data: map[string][]byte{"tls.crt": data, "tls.key": keyPEM(t, leaf.key)}}
+	}
+
+	valid := issue(t, leafTemplate(testNow.AddDate(0, 3, 0)), &root)
+	expiring := issue(t, leafTemplate(testNow.AddDate(0, 0, 10)), &root)
+	expired := issue(t, leafTemplate(testNow.Add(-time.Hour)), &root)
+	chained := issue(t, leafTemplate(testNow.AddDate(0, 3, 0)), &intermediate)
+	other := issue(t, leafTemplate(testNow.AddDate(0, 3, 0)), nil)
+
+	wrongKey := secret(valid)
+	wrongKey.Data["tls.key"] = keyPEM(t, other.key)
+	withCA := secret(valid)
+	withCA.Data["ca.crt"] = root.pem
+	wrongCA := secret(other)
+	wrongCA.Data["ca.crt"] = root.pem
+	// Written a month before expiry, two thirds into its lifetime
+	staleInstall := secret(valid)
+	staleInstall.ManagedFields = []metav1.ManagedFieldsEntry{dataWrite(valid.cert.NotAfter.AddDate(0, -1, 0))}
+	// Written early in its lifetime, then relabelled a month before expiry
+	relabelled := secret(valid)
+	relabelled.ManagedFields = []metav1.ManagedFieldsEntry{dataWrite(valid.cert.NotBefore), metadataWrite(valid.cert.NotAfter.AddDate(0, -1, 0))}
+	expiredInstall := secret(expired)
+	expiredInstall.CreationTimestamp = metav1.Time{Time: expired.cert.NotAfter.Add(-time.Minute)}
+
+	tests := []struct {
+		name       string
+		secret     corev1.Secret
+		opts       EvalOptions
+		wantStatus string
+		wantDays   int
+		wantEarly  []int
+		wantKey    bool
+		wantChain  bool
+		wantStale  bool
+	}{
+		{name: "valid", secret: secret(valid), opts: EvalOptions{WarnDays: 30}, wantStatus: StatusOK, wantDays: 92},
+		{name: "expiring", secret: secret(expiring), opts: EvalOptions{WarnDays: 30}, wantStatus: StatusWarning, wantDays: 10},
+		{name: "expiring without warn days", secret: secret(expiring), wantStatus: StatusOK, wantDays: 10},
+		{name: "expired", secret: secret(expired), opts: EvalOptions{WarnDays: 30, VerifyChain: true}, wantStatus: StatusExpired, wantDays: -1},
+		{name: "intermediate expiring first", secret: secret(chained, intermediate), wantStatus: StatusOK, wantDays: 92, wantEarly: []int{1}},
+		{name: "mismatched key", secret: wrongKey, wantStatus: StatusOK, wantDays: 92, wantKey: true},
+		{name: "chain verifies", secret: withCA, opts: EvalOptions{VerifyChain: true}, wantStatus: StatusOK, wantDays: 92},
+		{name: "chain does not verify", secret: wrongCA, opts: EvalOptions{VerifyChain: true}, wantStatus: StatusOK, wantDays: 92, wantChain: true},
+		{name: "chain not checked", secret: wrongCA, wantStatus: StatusOK, wantDays: 92},
+		{name: "stale install", secret: staleInstall, opts: EvalOptions{StaleInstallFraction: 0.5}, wantStatus: StatusOK, wantDays: 92, wantStale: true},
+		{name: "metadata write after install", secret: relabelled, opts: EvalOptions{StaleInstallFraction: 0.5}, wantStatus: StatusOK, wantDays: 92},
+		{name: "expired not stale", secret: expiredInstall, opts: EvalOptions{StaleInstallFraction: 0.5}, wantStatus: StatusExpired, wantDays: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Now = testNow
+			f, err := Evaluate(tt.secret, tt.opts)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			if f.Status != tt.wantStatus || f.DaysRemaining != tt.wantDays {
+				t.Errorf("Evaluate() = %s, %d days, want %s, %d days", f.Status, f.DaysRemaining, tt.wantStatus, tt.wantDays)
+			}
+			if len(f.EarlyIntermediates) != len(tt.wantEarly) || (len(tt.wantEarly) > 0 && f.EarlyIntermediates[0] != tt.wantEarly[0]) {
+				t.Errorf("EarlyIntermediates = %v, want %v", f.EarlyIntermediates, tt.wantEarly)
+			}
+			if (f.KeyError != "") != tt.wantKey {
+				t.Errorf("KeyError = %q, want set %v", f.KeyError, tt.wantKey)
+			}
+			if (f.ChainError != "") != tt.wantChain {
+				t.Errorf("ChainError = %q, want set %v", f.ChainError, tt.wantChain)
+			}
+			if f.StaleInstall != tt.wantStale {
+				t.Errorf("StaleInstall = %v, want %v", f.StaleInstall, tt.wantStale)
+			}
+		})
+	}
+
+	if _, err := Evaluate(corev1.Secret{Type: corev1.SecretTypeTLS}, EvalOptions{}); err == nil {
+		t.Error("Evaluate() without a certificate succeeded")
+	}
+}
+
+func TestEvaluateChain(t *testing.T) {
+	root := issueCA(t, "root", testNow.AddDate(5, 0, 0), nil)
+	intermediate := issueCA(t, "intermediate", testNow.AddDate(0, 0, 20), &root)
+	leaf := func(notAfter time.Time, parent *testCert) *x509.Certificate {
+		return issue(t, &x509.Certificate{DNSNames: []string{"example.com"}, NotAfter: notAfter}, parent).cert
+	}
+
+	tests := []struct {
+		name       string
+		chain      []*x509.Certificate
+		wantStatus string
+		wantDays   int
+		wantEarly  int
+	}{
+		{name: "valid", chain: []*x509.Certificate{leaf(testNow.AddDate(0, 3, 0), &root)}, wantStatus: StatusOK, wantDays: 92},
+		{name: "expiring", chain: []*x509.Certificate{leaf(testNow.AddDate(0, 0, 10), &root)}, wantStatus: StatusWarning, wantDays: 10},
+		{name: "expired", chain: []*x509.Certificate{leaf(testNow.Add(-time.Hour), &root)}, wantStatus: StatusExpired, wantDays: -1},
+		{name: "intermediate expiring first", chain: []*x509.Certificate{leaf(testNow.AddDate(0, 3, 0), &intermediate), intermediate.cert}, wantStatus: StatusOK, wantDays: 92, wantEarly: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := EvaluateChain(tt.chain, EvalOptions{WarnDays: 30, Now: testNow})
+			if f.Status != tt.wantStatus || f.DaysRemaining != tt.wantDays {
+				t.Errorf("EvaluateChain() = %s, %d days, want %s, %d days", f.Status, f.DaysRemaining, tt.wantStatus, tt.wantDays)
+			}
+			if len(f.EarlyIntermediates) != tt.wantEarly {
+				t.Errorf("EarlyIntermediates = %v, want %d", f.EarlyIntermediates, tt.wantEarly)
+			}
+		})
+	}
+}
+
+func TestEvaluateHosts(t *testing.T) {
+	leaf := issue(t, &x509.Certificate{
+		DNSNames:    []string{"example.com", "*.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		NotAfter:    testNow.AddDate(0, 3, 0),
+	}, nil)
+	secret := corev1.Secret{Type: corev1.SecretTypeTLS, Data: map[string][]byte{"tls.crt": leaf.pem}}
+
+	tests := []struct {
+		name  string
+		hosts []string
+		want  []string
+	}{
+		{name: "all covered", hosts: []string{"example.com", "www.example.com", "10.0.0.1", "*"}},
+		{name: "some uncovered", hosts: []string{"example.com", "a.b.example.com", "example.org", "10.0.0.2"}, want: []string{"a.b.example.com", "example.org", "10.0.0.2"}},
+		{name: "no hosts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := EvaluateHosts(secret, tt.hosts, EvalOptions{Now: testNow})
+			if err != nil {
+				t.Fatalf("EvaluateHosts() error = %v", err)
+			}
+			if strings.Join(f.UncoveredHosts, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("UncoveredHosts = %v, want %v", f.UncoveredHosts, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	root := issueCA(t, "root", testNow.AddDate(5, 0, 0), nil)
+	otherRoot := issueCA(t, "other root", testNow.AddDate(5, 0, 0), nil)
+	intermediate := issueCA(t, "intermediate", testNow.AddDate(1, 0, 0), &root)
+	leaf := issue(t, &x509.Certificate{DNSNames: []string{"example.com"}, NotAfter: testNow.AddDate(0, 3, 0)}, &intermediate)
+
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		chain   []*x509.Certificate
+		now     time.Time
+		wantErr string
+	}{
+		{name: "no ca bundle", chain: []*x509.Certificate{leaf.cert}},
+		{name: "empty ca bundle", data: map[string][]byte{"ca.crt": {}}, chain: []*x509.Certificate{leaf.cert}},
+		{name: "verifies", data: map[string][]byte{"ca.crt": root.pem}, chain: []*x509.Certificate{leaf.cert, intermediate.cert}},
+		{name: "istio cacert", data: map[string][]byte{"cacert": root.pem}, chain: []*x509.Certificate{leaf.cert, intermediate.cert}},
+		{name: "intermediate in the bundle", data: map[string][]byte{"ca.crt": intermediate.pem}, chain: []*x509.Certificate{leaf.cert}},
+		{name: "missing intermediate", data: map[string][]byte{"ca.crt": root.pem}, chain: []*x509.Certificate{leaf.cert}, wantErr: "chain does not verify against ca.crt"},
+		{name: "other ca", data: map[string][]byte{"ca.crt": otherRoot.pem}, chain: []*x509.Certificate{leaf.cert, intermediate.cert}, wantErr: "chain does not verify against ca.crt"},
+		{name: "expired at now", data: map[string][]byte{"ca.crt": root.pem}, chain: []*x509.Certificate{leaf.cert, intermediate.cert}, now: testNow.AddDate(1, 0, 0), wantErr: "expired"},
+		{name: "malformed ca bundle", data: map[string][]byte{"ca.crt": []byte("x")}, chain: []*x509.Certificate{leaf.cert}, wantErr: "ca.crt: no PEM certificate found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := tt.now
+			if now.IsZero() {
+				now = testNow
+			}
+			err := VerifyChain(corev1.Secret{Data: tt.data}, tt.chain, now)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("VerifyChain() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("VerifyChain() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatchPrivateKey(t *testing.T) {
+	leaf := issue(t, &x509.Certificate{DNSNames: []string{"example.com"}, NotAfter: testNow.AddDate(0, 3, 0)}, nil)
+	other := issue(t, &x509.Certificate{DNSNames: []string{"example.com"}, NotAfter: testNow.AddDate(0, 3, 0)}, nil)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaTemplate := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: testNow, NotAfter: testNow.AddDate(0, 3, 0)}
+	rsaDER, err := x509.CreateCertificate(rand.Reader, rsaTemplate, rsaTemplate, rsaKey.Public(), rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCert, err := x509.ParseCertificate(rsaDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	ecDER, err := x509.MarshalECPrivateKey(leaf.key.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1 := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})
+	// openssl ecparam -genkey writes the curve parameters (prime256v1) first
+	ecParams := pem.EncodeToMemory(&pem.Block{Type: "EC PARAMETERS", Bytes: []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}})
+
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		leaf    *x509.Certificate
+		wantErr string
+	}{
+		{name: "no private key", leaf: leaf.cert},
+		{name: "pkcs8 match", data: map[string][]byte{"tls.key": keyPEM(t, leaf.key)}, leaf: leaf.cert},
+		{name: "sec1 match", data: map[string][]byte{"tls.key": sec1}, leaf: leaf.cert},
+		{name: "ec parameters first", data: map[string][]byte{"tls.key": append(append([]byte{}, ecParams...), sec1...)}, leaf: leaf.cert},
+		{name: "ec parameters only", data: map[string][]byte{"tls.key": ecParams}, leaf: leaf.cert, wantErr: "tls.key: no PEM private key found"},
+		{name: "pkcs1 match", data: map[string][]byte{"tls.key": pkcs1}, leaf: rsaCert},
+		{name: "istio key", data: map[string][]byte{"key": keyPEM(t, leaf.key)}, leaf: leaf.cert},
+		{name: "mismatch", data: map[string][]byte{"tls.key": keyPEM(t, other.key)}, leaf: leaf.cert, wantErr: "tls.key does not match the certificate public key"},
+		{name: "other key type", data: map[string][]byte{"tls.key": pkcs1}, leaf: leaf.cert, wantErr: "does not match"},
+		{name: "not pem", data: map[string][]byte{"tls.key": []byte("x")}, leaf: leaf.cert, wantErr: "tls.key: no PEM private key found"},
+		{name: "garbage key", data: map[string][]byte{"tls.key": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("x")})}, leaf: leaf.cert, wantErr: "unable to parse private key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := MatchPrivateKey(corev1.Secret{Data: tt.data}, tt.leaf)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("MatchPrivateKey() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("MatchPrivateKey() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func dataWrite(at time.Time) metav1.ManagedFieldsEntry {
+	return metav1.ManagedFieldsEntry{
+		Manager:  "cert-manager",
+		Time:     &metav1.Time{Time: at},
+		FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:data":{".":{},"f:tls.crt":{},"f:tls.key":{}},"f:type":{}}`)},
+	}
+}
+
+func metadataWrite(at time.Time) metav1.ManagedFieldsEntry {
+	return metav1.ManagedFieldsEntry{
+		Manager:  "kubectl-label",
+		Time:     &metav1.Time{Time: at},
+		FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{"f:team":{}}}}`)},
+	}
+}
+
+func TestLastModified(t *testing.T) {
+	created := testNow.AddDate(0, -2, 0)
+	installed := testNow.AddDate(0, -1, 0)
+
+	tests := []struct {
+		name   string
+		fields []metav1.ManagedFieldsEntry
+		want   time.Time
+	}{
+		{name: "no managed fields", want: created},
+		{name: "data write", fields: []metav1.ManagedFieldsEntry{dataWrite(installed)}, want: installed},
+		{name: "string data write", fields: []metav1.ManagedFieldsEntry{{Time: &metav1.Time{Time: installed}, FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:stringData":{}}`)}}}, want: installed},
+		{name: "metadata write after data write", fields: []metav1.ManagedFieldsEntry{dataWrite(installed), metadataWrite(testNow)}, want: installed},
+		{name: "only metadata writes", fields: []metav1.ManagedFieldsEntry{metadataWrite(testNow)}, want: created},
+		{name: "entry without fields", fields: []metav1.ManagedFieldsEntry{{Time: &metav1.Time{Time: testNow}}}, want: created},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := corev1.Secret{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.Time{Time: created}, ManagedFields: tt.fields}}
+			if got := LastModified(secret); !got.Equal(tt.want) {
+				t.Errorf("LastModified() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDaysRemaining(t *testing.T) {
+	midnight := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		notAfter time.Time
+		now      time.Time
+		want     int
+	}{
+		{"exactly 30 days at midnight", midnight.AddDate(0, 0, 30), midnight, 30},
+		{"a second short of 30 days", midnight.AddDate(0, 0, 30), midnight.Add(time.Second), 29},
+		{"expires now", midnight, midnight, 0},
+		{"expired a second ago", midnight, midnight.Add(time.Second), -1},
+		{"other zone, same instant", midnight.AddDate(0, 0, 30).In(time.FixedZone("UTC+2", 2*3600)), midnight, 30},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DaysRemaining(tt.notAfter, tt.now); got != tt.want {
+				t.Errorf("DaysRemaining() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
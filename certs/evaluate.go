@@ -0,0 +1,155 @@
+package certs
+
+import (
+	"crypto/x509"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Statuses of an evaluated certificate, worst last.
+const (
+	StatusOK      = "ok"
+	StatusWarning = "warning"
+	StatusExpired = "expired"
+)
+
+// EvalOptions holds the thresholds applied by Evaluate.
+type EvalOptions struct {
+	// WarnDays turns certificates with fewer days left into warnings, 0 disables it.
+	WarnDays int
+
+	// StaleInstallFraction flags certificates installed with less than this
+	// share of their lifetime left, 0 disables it.
+	StaleInstallFraction float64
+
+	// Now is the instant expiry is evaluated against, the zero value means
+	// time.Now.
+	Now time.Time
+
+	// VerifyChain checks the chain against the CA bundle of the secret. Leave
+	// it off when that bundle is a client CA, as for Istio MUTUAL servers.
+	VerifyChain bool
+}
+
+// Finding is the outcome of evaluating one secret.
+type Finding struct {
+	Status        string
+	NotAfter      time.Time
+	DaysRemaining int
+
+	// Chain as stored in the secret, leaf first
+	Chain []*x509.Certificate
+
+	// Intermediates expiring before the leaf, as indexes into Chain
+	EarlyIntermediates []int
+
+	// Set when the certificate was installed with less than
+	// StaleInstallFraction of its lifetime left
+	StaleInstall bool
+	InstalledAt  time.Time
+	LifetimeLeft float64
+
+	// Hosts not covered by the leaf, only set by EvaluateHosts
+	UncoveredHosts []string
+
+	// Why the chain doesn't verify against the CA bundle of the secret, only
+	// set with VerifyChain
+	ChainError string
+
+	// Why the private key of the secret doesn't belong to the leaf
+	KeyError string
+}
+
+// Evaluate runs the certificate analysis on a secret already fetched by the
+// caller. It returns an error when the secret holds no usable certificate.
+func Evaluate(secret corev1.Secret, opts EvalOptions) (Finding, error) {
+	chain, err := SecretCertificates(secret)
+	if err != nil {
+		return Finding{}, err
+	}
+
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	f := EvaluateChain(chain, EvalOptions{WarnDays: opts.WarnDays, Now: now})
+	leaf := chain[0]
+
+	// A mismatched key fails every handshake, whatever the expiry
+	if err := MatchPrivateKey(secret, leaf); err != nil {
+		f.KeyError = err.Error()
+	}
+
+	// Expired certificates would fail verification for that reason alone
+	if opts.VerifyChain && f.Status != StatusExpired {
+		if err := VerifyChain(secret, chain, now); err != nil {
+			f.ChainError = err.Error()
+		}
+	}
+
+	// Catch rotations that uploaded an already aging certificate, expired
+	// ones are reported as such
+	if opts.StaleInstallFraction > 0 && f.Status != StatusExpired {
+		installed := LastModified(secret)
+		left, ok := LifetimeLeftAtInstall(leaf, installed)
+		if ok && left < opts.StaleInstallFraction {
+			f.StaleInstall, f.InstalledAt, f.LifetimeLeft = true, installed, left
+		}
+	}
+
+	return f, nil
+}
+
+// EvaluateChain runs the expiry analysis on a non-empty chain, leaf first,
+// presented live rather than stored in a secret. Only WarnDays and Now apply:
+// there is no private key, CA bundle or install time to check.
+func EvaluateChain(chain []*x509.Certificate, opts EvalOptions) Finding {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// The leaf comes first, the rest of the chain follows it
+	leaf := chain[0]
+	f := Finding{
+		NotAfter:      leaf.NotAfter,
+		DaysRemaining: DaysRemaining(leaf.NotAfter, now),
+		Chain:         chain,
+	}
+
+	switch {
+	case now.After(leaf.NotAfter):
+		f.Status = StatusExpired
+	case opts.WarnDays > 0 && f.DaysRemaining < opts.WarnDays:
+		f.Status = StatusWarning
+	default:
+		f.Status = StatusOK
+	}
+
+	// An intermediate expiring first breaks the chain before the leaf does
+	for i, cert := range chain[1:] {
+		if cert.NotAfter.Before(leaf.NotAfter) {
+			f.EarlyIntermediates = append(f.EarlyIntermediates, i+1)
+		}
+	}
+
+	return f
+}
+
+// EvaluateHosts is Evaluate also checking that the leaf covers every host,
+// listing the others in UncoveredHosts.
+func EvaluateHosts(secret corev1.Secret, hosts []string, opts EvalOptions) (Finding, error) {
+	f, err := Evaluate(secret, opts)
+	if err != nil {
+		return f, err
+	}
+
+	for _, host := range hosts {
+		if !HostCovered(host, f.Chain[0]) {
+			f.UncoveredHosts = append(f.UncoveredHosts, host)
+		}
+	}
+
+	return f, nil
+}
@@ -0,0 +1,101 @@
+package certs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// A kubernetes.io/tls secret holding a self-signed certificate for dnsNames
+func exampleSecret(notAfter time.Time, dnsNames ...string) corev1.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    notAfter.AddDate(0, -3, 0),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+
+	return corev1.Secret{
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"tls.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			"tls.key": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		},
+	}
+}
+
+func ExampleEvaluate() {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	secret := exampleSecret(now.AddDate(0, 0, 10), "example.com")
+
+	finding, err := certs.Evaluate(secret, certs.EvalOptions{WarnDays: 30, Now: now})
+	if err != nil {
+		fmt.Println("no usable certificate:", err)
+		return
+	}
+	fmt.Println(finding.Status, finding.DaysRemaining)
+	// Output: warning 10
+}
+
+func ExampleEvaluateHosts() {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	secret := exampleSecret(now.AddDate(1, 0, 0), "example.com", "*.example.com")
+
+	finding, err := certs.EvaluateHosts(secret, []string{"www.example.com", "a.b.example.com", "example.org"}, certs.EvalOptions{Now: now})
+	if err != nil {
+		fmt.Println("no usable certificate:", err)
+		return
+	}
+	fmt.Println(finding.Status, finding.UncoveredHosts)
+	// Output: ok [a.b.example.com example.org]
+}
+
+func ExampleHostCovered() {
+	secret := exampleSecret(time.Now().AddDate(1, 0, 0), "*.example.com")
+	chain, err := certs.SecretCertificates(secret)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, host := range []string{"www.example.com", "example.com", "*.example.com", "*"} {
+		fmt.Println(host, certs.HostCovered(host, chain[0]))
+	}
+	// Output:
+	// www.example.com true
+	// example.com false
+	// *.example.com true
+	// * true
+}
+
+func ExampleDaysRemaining() {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	fmt.Println(certs.DaysRemaining(now.Add(36*time.Hour), now))
+	fmt.Println(certs.DaysRemaining(now.Add(-36*time.Hour), now))
+	// Output:
+	// 1
+	// -2
+}
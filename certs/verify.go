@@ -0,0 +1,126 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// CAKeys lists the secret keys looked up for a CA bundle, in order.
+var CAKeys = []string{"ca.crt", "cacert"}
+
+// PrivateKeyKeys lists the secret keys looked up for the private key, in order.
+var PrivateKeyKeys = []string{"tls.key", "key"}
+
+// VerifyChain checks that chain verifies at now against the CA bundle stored
+// in the secret, using the rest of chain as intermediates. Secrets without a
+// CA bundle are not checked.
+func VerifyChain(secret corev1.Secret, chain []*x509.Certificate, now time.Time) error {
+	for _, key := range CAKeys {
+		data, ok := secret.Data[key]
+		if !ok || len(data) == 0 {
+			continue
+		}
+
+		cas, err := ParseCertificates(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		roots := x509.NewCertPool()
+		for _, ca := range cas {
+			roots.AddCert(ca)
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range chain[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err = chain[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   now,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return fmt.Errorf("chain does not verify against %s: %v", key, err)
+		}
+		return nil
+	}
+
+	return nil
+}
+
+// MatchPrivateKey checks that the private key stored in the secret belongs to
+// leaf. Secrets without a private key are not checked.
+func MatchPrivateKey(secret corev1.Secret, leaf *x509.Certificate) error {
+	for _, key := range PrivateKeyKeys {
+		data, ok := secret.Data[key]
+		if !ok {
+			continue
+		}
+
+		block := privateKeyBlock(data)
+		if block == nil {
+			return fmt.Errorf("%s: no PEM private key found", key)
+		}
+		private, err := parsePrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+
+		public, ok := private.Public().(interface{ Equal(crypto.PublicKey) bool })
+		if !ok || !public.Equal(leaf.PublicKey) {
+			return fmt.Errorf("%s does not match the certificate public key", key)
+		}
+		return nil
+	}
+
+	return nil
+}
+
+// privateKeyBlock returns the first private key block of data, skipping blocks
+// such as the EC PARAMETERS written by openssl ecparam -genkey.
+func privateKeyBlock(data []byte) *pem.Block {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			return block
+		}
+	}
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key")
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+func loadCABundle(file string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA bundle: %v", err)
+	}
+
+	return caFingerprints(data)
+}
+
+func caFingerprints(data []byte) (map[string]string, error) {
+	parsed, err := certs.ParseCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fingerprint -> subject, for readable findings
+	fingerprints := map[string]string{}
+	for _, cert := range parsed {
+		fingerprints[fmt.Sprintf("%x", sha256.Sum256(cert.Raw))] = cert.Subject.String()
+	}
+
+	return fingerprints, nil
+}
+
+func clientCAData(kclient *kubernetes.Clientset, secret corev1.Secret) ([]byte, error) {
+	if data, ok := secret.Data["ca.crt"]; ok {
+		return data, nil
+	}
+
+	// Istio also reads the CA from a separate <credentialName>-cacert secret
+	caSecret, err := kclient.CoreV1().Secrets(secret.Namespace).Get(context.TODO(), secret.Name+"-cacert", metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("no ca.crt in secret and no %s-cacert secret: %v", secret.Name, err)
+	}
+	for _, key := range []string{"ca.crt", "cacert"} {
+		if data, ok := caSecret.Data[key]; ok {
+			return data, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no ca.crt or cacert key in secret %s-cacert", secret.Name)
+}
+
+func sortedSubjects(fingerprints map[string]string, exclude map[string]string) []string {
+	var subjects []string
+	for fp, subject := range fingerprints {
+		if _, ok := exclude[fp]; !ok {
+			subjects = append(subjects, subject)
+		}
+	}
+	sort.Strings(subjects)
+
+	return subjects
+}
+
+func checkGatewayClientCA(w io.Writer, kclient *kubernetes.Clientset, gw unstructured.Unstructured, secrets []corev1.Secret, expected map[string]string) {
+	bySecret := map[string]corev1.Secret{}
+	for _, secret := range secrets {
+		bySecret[secret.Name] = secret
+	}
+
+	servers, _, _ := unstructured.NestedSlice(gw.Object, "spec", "servers")
+	for _, serverObj := range servers {
+		server, ok := serverObj.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		mode, _, _ := unstructured.NestedString(server, "tls", "mode")
+		credentialName, _, _ := unstructured.NestedString(server, "tls", "credentialName")
+		secret, ok := bySecret[credentialName]
+		if mode != "MUTUAL" || !ok {
+			continue
+		}
+
+		data, err := clientCAData(kclient, secret)
+		if err != nil {
+			fmt.Fprintf(w, "error getting client CA for secret %s in gateway %s in namespace %s: %v\n", credentialName, gw.GetName(), gw.GetNamespace(), err)
+			continue
+		}
+
+		actual, err := caFingerprints(data)
+		if err != nil {
+			fmt.Fprintf(w, "error parsing client CA for secret %s in gateway %s in namespace %s: %v\n", credentialName, gw.GetName(), gw.GetNamespace(), err)
+			continue
+		}
+
+		// Missing and extra CAs are separate findings
+		for _, subject := range sortedSubjects(expected, actual) {
+			fmt.Fprintf(w, "Client CA in secret %s in gateway %s in namespace %s is missing expected CA %s\n", credentialName, gw.GetName(), gw.GetNamespace(), subject)
+		}
+		for _, subject := range sortedSubjects(actual, expected) {
+			fmt.Fprintf(w, "Client CA in secret %s in gateway %s in namespace %s contains unexpected CA %s\n", credentialName, gw.GetName(), gw.GetNamespace(), subject)
+		}
+	}
+}
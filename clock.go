@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Instant expiry math is evaluated against, moved by --as-of
+var clock = time.Now
+
+func parseAsOf(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	// A bare date means midnight UTC
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --as-of %q, expected YYYY-MM-DD or RFC 3339", value)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+func TestParseAsOf(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "2025-12-01", want: time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{value: "2025-12-01T08:30:00Z", want: time.Date(2025, 12, 1, 8, 30, 0, 0, time.UTC)},
+		{value: "2025-12-01T08:30:00+02:00", want: time.Date(2025, 12, 1, 6, 30, 0, 0, time.UTC)},
+		{value: "2025-12-01 08:30", wantErr: true},
+		{value: "01/12/2025", wantErr: true},
+		{value: "2025-13-01", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseAsOf(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAsOf(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Equal(tt.want) {
+				t.Errorf("parseAsOf(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAsOfBoundaries(t *testing.T) {
+	defer func(days int, fraction float64, value string) {
+		*warnDays, *staleInstallFraction, *asOf = days, fraction, value
+		clock = time.Now
+	}(*warnDays, *staleInstallFraction, *asOf)
+	*warnDays, *staleInstallFraction, *asOf = 30, 0, "2025-12-01"
+
+	now, err := parseAsOf(*asOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock = func() time.Time { return now }
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name       string
+		notAfter   time.Time
+		wantStatus string
+		wantDays   int
+	}{
+		{"exactly at the threshold", now.Add(30 * day), statusOK, 30},
+		{"a second inside the threshold", now.Add(30*day - time.Second), statusWarning, 29},
+		{"next midnight", now.Add(day), statusWarning, 1},
+		{"a second before next midnight", now.Add(day - time.Second), statusWarning, 0},
+		{"expiring at the instant", now, statusWarning, 0},
+		{"expired a second before", now.Add(-time.Second), statusExpired, -1},
+		{"expired the day before", now.Add(-day), statusExpired, -1},
+		{"expired a second more than a day before", now.Add(-day - time.Second), statusExpired, -2},
+	}
+
+	// Certificates are valid long before the simulated instant, whatever the real time
+	var cases []selfTestCase
+	for i, tt := range tests {
+		name := "boundary-" + string(rune('a'+i))
+		host := name + ".example.com"
+		cases = append(cases, selfTestCase{
+			name: tt.name, secret: name, hosts: []string{host},
+			build: selfTestSecret(name, selfTestCertificate{notBefore: now.Add(-90 * day), notAfter: tt.notAfter, dnsNames: []string{host}}),
+		})
+	}
+	secrets := map[string]*corev1.Secret{}
+	for _, tc := range cases {
+		secret, err := tc.build()
+		if err != nil {
+			t.Fatalf("building %s: %v", tc.name, err)
+		}
+		secrets[tc.secret] = secret
+	}
+	srv := selfTestServer(cases, secrets)
+	defer srv.Close()
+	kclient, dclient, err := newClients(&rest.Config{Host: srv.URL, QPS: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scan := &scanContext{buffered: true, drift: newDriftTracker(nil), sources: map[string]bool{sourceIstio: true}}
+	scan.scanNamespace(kclient, dclient, selfTestNamespace, newAbsentGroups(scan.cluster, nil))
+	bySecret := map[string]result{}
+	for _, r := range scan.results {
+		bySecret[r.Secret] = r
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok := bySecret[cases[i].secret]
+			if !ok {
+				t.Fatalf("no result for %s", cases[i].secret)
+			}
+			if r.Status != tt.wantStatus || r.DaysRemaining == nil || *r.DaysRemaining != tt.wantDays {
+				t.Errorf("status, days = %s, %v, want %s, %d (%s)", r.Status, r.DaysRemaining, tt.wantStatus, tt.wantDays, r.Error)
+			}
+		})
+	}
+
+	// The report is marked as simulated
+	if rep := newReport(scan, scan.results); rep.AsOf == nil || !rep.AsOf.Equal(now) {
+		t.Errorf("report asOf = %v, want %s", rep.AsOf, now)
+	}
+}
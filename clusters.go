@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const clusterNameAnnotation = "check-secrets/cluster-name"
+
+type clusterTarget struct {
+	name string
+	// Requests made with the clients fail once ctx is done
+	connect func(ctx context.Context) (*kubernetes.Clientset, dynamic.Interface, error)
+}
+
+func contextTargets(kubeconfig, skip string, only []string) ([]clusterTarget, error) {
+	contexts, err := listContexts(kubeconfig, skip)
+	if err != nil {
+		return nil, err
+	}
+
+	// --contexts scans the given ones in the given order, a typo is an error rather than a skipped cluster
+	if len(only) > 0 {
+		for _, kctx := range only {
+			if !slices.Contains(contexts, kctx) {
+				return nil, fmt.Errorf("context %s not found in the kubeconfig or skipped by --skip-contexts", kctx)
+			}
+		}
+		contexts = only
+	}
+
+	var targets []clusterTarget
+	for _, kctx := range contexts {
+		kctx := kctx
+		targets = append(targets, clusterTarget{
+			name: kctx,
+			connect: func(ctx context.Context) (*kubernetes.Clientset, dynamic.Interface, error) {
+				return k8sClient(clientOptions{
+					kubeconfig:     kubeconfig,
+					context:        kctx,
+					timeout:        *requestTimeout,
+					proxyURL:       *proxyURL,
+					clusterTimeout: *clusterTimeout,
+					ctx:            ctx,
+				})
+			},
+		})
+	}
+
+	return targets, nil
+}
+
+func hubTargets(kclient *kubernetes.Clientset, selector, key string) ([]clusterTarget, error) {
+	secretList, err := kclient.CoreV1().Secrets(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list hub secrets: %v", err)
+	}
+
+	var targets []clusterTarget
+	for _, secret := range secretList.Items {
+		// cluster-api names these secrets <cluster>-kubeconfig
+		name := secret.Annotations[clusterNameAnnotation]
+		if name == "" {
+			name = strings.TrimSuffix(secret.Name, "-kubeconfig")
+		}
+
+		// The kubeconfig stays in memory and is never printed
+		data := secret.Data[key]
+		secretName := secret.Namespace + "/" + secret.Name
+		targets = append(targets, clusterTarget{
+			name: name,
+			connect: func(ctx context.Context) (*kubernetes.Clientset, dynamic.Interface, error) {
+				if len(data) == 0 {
+					return nil, nil, fmt.Errorf("key %s not found in hub secret %s", key, secretName)
+				}
+
+				restConfig, err := clientcmd.RESTConfigFromKubeConfig(data)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid kubeconfig in hub secret %s", secretName)
+				}
+
+				// Never run commands shipped inside a cluster secret
+				if restConfig.ExecProvider != nil || restConfig.AuthProvider != nil {
+					return nil, nil, fmt.Errorf("kubeconfig in hub secret %s uses an auth plugin, which is not allowed", secretName)
+				}
+
+				return clientsForConfig(restConfig, clientOptions{
+					timeout:        *requestTimeout,
+					proxyURL:       *proxyURL,
+					clusterTimeout: *clusterTimeout,
+					ctx:            ctx,
+				})
+			},
+		})
+	}
+
+	return targets, nil
+}
+
+// Cluster scan outcomes in the status block
+const (
+	clusterScanned  = "scanned"
+	clusterFailed   = "failed"
+	clusterTimedOut = "timed out"
+)
+
+type clusterStatus struct {
+	Name     string         `json:"name"`
+	Status   string         `json:"status"`
+	Duration string         `json:"duration"`
+	Findings map[string]int `json:"findings,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+func (scan *scanContext) fork(cluster string) *scanContext {
+	return &scanContext{
+		cluster:   cluster,
+		exporter:  scan.exporter,
+		archive:   scan.archive,
+		silences:  scan.silences,
+		baseline:  scan.baseline,
+		drift:     scan.drift,
+		clientCAs: scan.clientCAs,
+		timings:   scan.timings,
+		sources:   scan.sources,
+	}
+}
+
+// Where diagnostics go, held back with the results when buffered
+func (scan *scanContext) out() io.Writer {
+	if scan.buffered {
+		return &scan.output
+	}
+
+	return os.Stdout
+}
+
+func (scan *scanContext) merge(child *scanContext) {
+	// Buffered children are printed by the parent unless it buffers too,
+	// their diagnostics first, then the results
+	if child.buffered {
+		_, _ = child.output.WriteTo(scan.out())
+	}
+	if !scan.buffered && child.buffered {
+		for _, r := range child.results {
+			scan.printResult(r)
+		}
+	}
+
+	scan.results = append(scan.results, child.results...)
+	scan.errors = append(scan.errors, child.errors...)
+	scan.workloads = append(scan.workloads, child.workloads...)
+	scan.disabled = append(scan.disabled, child.disabled...)
+	for manager, count := range child.managers {
+		if scan.managers == nil {
+			scan.managers = map[string]int{}
+		}
+		scan.managers[manager] += count
+	}
+}
+
+func scanTarget(ctx context.Context, target clusterTarget, scan *scanContext) error {
+	kclient, dclient, err := target.connect(ctx)
+	if err != nil {
+		fmt.Fprintf(scan.out(), "error creating the k8s clients for cluster %s: %v\n", target.name, err)
+		scan.recordError("cluster "+target.name, "", err, true)
+		return err
+	}
+
+	nsList, err := getNamespaces(kclient)
+	if err != nil {
+		fmt.Fprintf(scan.out(), "error getting the list of namespaces for cluster %s: %v\n", target.name, err)
+		scan.recordError("cluster "+target.name, "", err, true)
+		return err
+	}
+
+	err = scanCluster(kclient, dclient, nsList, scan)
+	if err != nil {
+		fmt.Fprintf(scan.out(), "error scanning cluster %s: %v\n", target.name, err)
+	}
+
+	return err
+}
+
+func scanClusters(targets []clusterTarget, scan *scanContext, concurrency int, timeout time.Duration) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	scans := make([]*scanContext, len(targets))
+	statuses := make([]clusterStatus, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	// One unreachable or hanging cluster must not stop the others
+	for i, target := range targets {
+		i, target := i, target
+		cs := scan.fork(target.name)
+
+		// Concurrent clusters would interleave, so their lines are printed once done
+		cs.buffered = scan.buffered || concurrency > 1
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if !cs.buffered {
+				fmt.Printf("Cluster %s:\n", target.name)
+			}
+
+			start := time.Now()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- scanTarget(ctx, target, cs) }()
+
+			var expired <-chan time.Time
+			if timeout > 0 {
+				expired = time.After(timeout)
+			}
+
+			status := clusterStatus{Name: target.name, Status: clusterScanned}
+			select {
+			case err := <-done:
+				if err != nil {
+					status.Status, status.Error = clusterFailed, err.Error()
+					if classifyError(err) == errTimeout {
+						status.Status = clusterTimedOut
+					}
+				}
+			case <-expired:
+				// Fail its requests and wait for it to stop writing the shared
+				// drift, timings, archive and export state, then drop what it collected
+				cancel()
+				<-done
+				err := fmt.Errorf("cluster scan did not finish within %s", timeout)
+				cs = scan.fork(target.name)
+				cs.buffered = scan.buffered || concurrency > 1
+				fmt.Fprintf(cs.out(), "error scanning cluster %s: %v\n", target.name, err)
+				cs.recordError("cluster "+target.name, "", context.DeadlineExceeded, true)
+				status.Status, status.Error = clusterTimedOut, err.Error()
+			}
+			status.Duration = time.Since(start).Round(time.Millisecond).String()
+
+			status.Findings = map[string]int{}
+			for _, r := range cs.results {
+				status.Findings[r.Status]++
+			}
+
+			scans[i], statuses[i] = cs, status
+		}()
+	}
+	wg.Wait()
+
+	for _, cs := range scans {
+		if cs.buffered && !scan.buffered {
+			fmt.Printf("Cluster %s:\n", cs.cluster)
+		}
+		scan.merge(cs)
+	}
+	scan.clusters = statuses
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tSTATUS\tDURATION\tOK\tWARNING\tEXPIRED\tERROR")
+	for _, status := range statuses {
+		f := status.Findings
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%d\n", status.Name, status.Status, status.Duration, f[statusOK], f[statusWarning], f[statusExpired], f[statusError])
+	}
+	w.Flush()
+}
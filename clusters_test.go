@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestScanClustersTimeout(t *testing.T) {
+	namespaces.values = []string{selfTestNamespace}
+	defer func() { namespaces.values = nil }()
+	*noRecheck = true
+	defer func() { *noRecheck = false }()
+
+	cases := selfTestCases(time.Now())
+	secrets := map[string]*corev1.Secret{}
+	for _, tc := range cases {
+		if tc.build == nil {
+			continue
+		}
+		secret, err := tc.build()
+		if err != nil {
+			t.Fatalf("building %s: %v", tc.name, err)
+		}
+		secrets[tc.secret] = secret
+	}
+	healthy := selfTestServer(cases, secrets)
+	defer healthy.Close()
+
+	// Never answers, until the client gives up on the request
+	var returned atomic.Bool
+	var active, late atomic.Int32
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		active.Add(1)
+		defer active.Add(-1)
+		if returned.Load() {
+			late.Add(1)
+		}
+		<-r.Context().Done()
+	}))
+	defer hanging.Close()
+
+	target := func(name, url string) clusterTarget {
+		return clusterTarget{
+			name: name,
+			connect: func(ctx context.Context) (*kubernetes.Clientset, dynamic.Interface, error) {
+				return clientsForConfig(&rest.Config{Host: url}, clientOptions{ctx: ctx})
+			},
+		}
+	}
+	targets := []clusterTarget{
+		target("a", healthy.URL),
+		target("hanging", hanging.URL),
+		target("b", healthy.URL),
+	}
+
+	tests := []struct {
+		name        string
+		concurrency int
+	}{
+		{"sequential", 1},
+		{"concurrent", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returned.Store(false)
+			scan := &scanContext{buffered: true, drift: newDriftTracker(nil), sources: map[string]bool{sourceIstio: true}}
+			scanClusters(targets, scan, tt.concurrency, time.Second)
+			returned.Store(true)
+
+			want := map[string]string{"a": clusterScanned, "hanging": clusterTimedOut, "b": clusterScanned}
+			for _, status := range scan.clusters {
+				if status.Status != want[status.Name] {
+					t.Errorf("cluster %s status = %s, want %s", status.Name, status.Status, want[status.Name])
+				}
+			}
+
+			perCluster := map[string]int{}
+			for _, r := range scan.results {
+				perCluster[r.Cluster]++
+			}
+			if perCluster["a"] == 0 || perCluster["a"] != perCluster["b"] {
+				t.Errorf("results per cluster = %v, want the same non-zero count for a and b", perCluster)
+			}
+			if perCluster["hanging"] != 0 {
+				t.Errorf("%d results from the timed out cluster", perCluster["hanging"])
+			}
+
+			// The timed out scan was cancelled and waited for: its request is
+			// dropped and it sends nothing once scanClusters returned
+			deadline := time.Now().Add(time.Second)
+			for active.Load() != 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if n := active.Load(); n != 0 {
+				t.Errorf("%d requests to the timed out cluster still in flight", n)
+			}
+			if n := late.Load(); n != 0 {
+				t.Errorf("%d requests to the timed out cluster after scanClusters returned", n)
+			}
+		})
+	}
+}
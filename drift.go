@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const replicatedAnnotation = "check-secrets/replicated"
+
+type replicaCopy struct {
+	location    string
+	fingerprint string
+	serial      string
+	notAfter    time.Time
+}
+
+type driftTracker struct {
+	mu     sync.Mutex
+	names  map[string]bool
+	copies map[string]map[string]replicaCopy // secret name -> location -> copy
+}
+
+func newDriftTracker(names []string) *driftTracker {
+	t := &driftTracker{names: map[string]bool{}, copies: map[string]map[string]replicaCopy{}}
+	for _, name := range names {
+		if name != "" {
+			t.names[name] = true
+		}
+	}
+
+	return t
+}
+
+func (t *driftTracker) record(cluster string, secret corev1.Secret) {
+	// Only secrets declared as replicated are compared
+	if !t.names[secret.Name] && secret.Annotations[replicatedAnnotation] != "true" {
+		return
+	}
+
+	chain, err := certs.SecretCertificates(secret)
+	if err != nil {
+		return
+	}
+
+	location := secret.Namespace
+	if cluster != "" {
+		location = cluster + "/" + location
+	}
+
+	// Clusters may be scanned concurrently
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.copies[secret.Name] == nil {
+		t.copies[secret.Name] = map[string]replicaCopy{}
+	}
+	t.copies[secret.Name][location] = replicaCopy{
+		location:    location,
+		fingerprint: fmt.Sprintf("%x", sha256.Sum256(chain[0].Raw)),
+		serial:      chain[0].SerialNumber.Text(16),
+		notAfter:    chain[0].NotAfter,
+	}
+}
+
+func (t *driftTracker) report() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var names []string
+	for name := range t.copies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var copies []replicaCopy
+		fingerprints := map[string]bool{}
+		for _, c := range t.copies[name] {
+			copies = append(copies, c)
+			fingerprints[c.fingerprint] = true
+		}
+		if len(fingerprints) < 2 {
+			continue
+		}
+
+		sort.Slice(copies, func(i, j int) bool { return copies[i].location < copies[j].location })
+		var lines []string
+		for _, c := range copies {
+			lines = append(lines, fmt.Sprintf("  %s: serial %s, expiration date %s", c.location, c.serial, c.notAfter.UTC().Format(opensslTimeFormat)))
+		}
+		fmt.Printf("Credential drift for replicated secret %s:\n%s\n", name, strings.Join(lines, "\n"))
+	}
+}
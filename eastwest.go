@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const eastWestPort = 15443
+
+func getEastWestAddress(svc corev1.Service) string {
+	// Prefer the load balancer address used by remote networks
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP
+		}
+		if ingress.Hostname != "" {
+			return ingress.Hostname
+		}
+	}
+
+	return svc.Spec.ClusterIP
+}
+
+// AUTO_PASSTHROUGH servers route on SNI, sni must name a service exposed
+// through port 15443 for the gateway to complete a handshake
+func (scan *scanContext) checkEastWestGateways(kclient *kubernetes.Clientset, selector string, sni string, timeout time.Duration) error {
+	svcList, err := kclient.CoreV1().Services(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("unable to list east-west gateway services: %v", err)
+	}
+
+	if len(svcList.Items) == 0 {
+		fmt.Fprintf(scan.out(), "No east-west gateway services found matching %s\n", selector)
+		return nil
+	}
+
+	for _, svc := range svcList.Items {
+		addr := getEastWestAddress(svc)
+		if addr == "" || addr == corev1.ClusterIPNone {
+			fmt.Fprintf(scan.out(), "warning: east-west gateway %s in namespace %s has no address to connect to\n", svc.Name, svc.Namespace)
+			continue
+		}
+		addr = net.JoinHostPort(addr, strconv.Itoa(eastWestPort))
+
+		// Only the presented chain is needed, Istio certs are not trusted locally
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, &tls.Config{
+			ServerName:         sni,
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			fmt.Fprintf(scan.out(), "warning: unable to connect to east-west gateway %s in namespace %s at %s: %v\n", svc.Name, svc.Namespace, addr, err)
+			scan.recordError("east-west gateway "+svc.Name, svc.Namespace, err, false)
+			continue
+		}
+		chain := conn.ConnectionState().PeerCertificates
+		conn.Close()
+
+		scan.reportEastWest(svc, addr, sni, chain)
+	}
+
+	return nil
+}
+
+func (scan *scanContext) reportEastWest(svc corev1.Service, addr, sni string, chain []*x509.Certificate) {
+	r := result{
+		Namespace:    svc.Namespace,
+		ReferencedBy: []referrer{{Kind: kindEastWestGateway, Name: svc.Name}},
+		Ports:        []int64{eastWestPort},
+		Hosts:        []string{sni},
+	}
+	if len(chain) == 0 {
+		r.setError(findingCertInvalid, fmt.Errorf("no certificate presented"), fmt.Sprintf("error analyzing certificate presented by east-west gateway %s in namespace %s at %s: no certificate presented", svc.Name, svc.Namespace, addr))
+		scan.emit(r)
+		return
+	}
+	r.setFinding(certs.EvaluateChain(chain, certs.EvalOptions{WarnDays: *warnDays, Now: clock()}))
+
+	var lines []string
+	for i, cert := range chain {
+		lines = append(lines, fmt.Sprintf("Certificate %d (%s) presented by east-west gateway %s in namespace %s at %s expiration date is %s", i, cert.Subject.String(), svc.Name, svc.Namespace, addr, cert.NotAfter.UTC().Format(opensslTimeFormat)))
+	}
+	if s := findSilence(scan.silences, scan.cluster, svc.Namespace, "", findingID(scan.cluster, r)); s != nil {
+		r.Silenced = s.String()
+		lines[0] += fmt.Sprintf(" (%s)", s)
+	}
+	r.text = strings.Join(lines, "\n")
+	scan.emit(r)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReportEastWest(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	defer func(days int) { *warnDays, clock = days, time.Now }(*warnDays)
+	*warnDays, clock = 30, func() time.Time { return now }
+
+	leaf := func(notAfter time.Time) []*x509.Certificate {
+		cert, _, err := selfTestCA("eastwest", now.AddDate(0, -1, 0), notAfter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []*x509.Certificate{cert}
+	}
+	svc := corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "istio-eastwestgateway", Namespace: "istio-system"}}
+	const sni = "outbound_.8080_._.httpbin.sample.svc.cluster.local"
+
+	tests := []struct {
+		name     string
+		chain    []*x509.Certificate
+		wantCode string
+		wantExit int
+	}{
+		{name: "valid", chain: leaf(now.AddDate(0, 3, 0)), wantCode: findingCertOK},
+		{name: "expiring", chain: leaf(now.AddDate(0, 0, 10)), wantCode: findingCertExpiring, wantExit: exitWarning},
+		{name: "expired", chain: leaf(now.Add(-time.Hour)), wantCode: findingCertExpired, wantExit: exitCritical},
+		{name: "no certificate", wantCode: findingCertInvalid, wantExit: exitCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scan := &scanContext{cluster: "east", buffered: true}
+			scan.reportEastWest(svc, "10.0.0.1:15443", sni, tt.chain)
+
+			if len(scan.results) != 1 {
+				t.Fatalf("got %d results, want 1", len(scan.results))
+			}
+			r := scan.results[0]
+			if r.Code != tt.wantCode || r.Cluster != "east" || r.Namespace != "istio-system" {
+				t.Errorf("result = %s in %s/%s, want %s in east/istio-system", r.Code, r.Cluster, r.Namespace, tt.wantCode)
+			}
+			if len(r.ReferencedBy) != 1 || r.ReferencedBy[0].String() != "east-west gateway istio-eastwestgateway" {
+				t.Errorf("referenced by %v, want the east-west gateway", r.ReferencedBy)
+			}
+			if !strings.Contains(r.text, "east-west gateway istio-eastwestgateway in namespace istio-system at 10.0.0.1:15443") {
+				t.Errorf("text = %q", r.text)
+			}
+			if got := exitCode(scan.results); got != tt.wantExit {
+				t.Errorf("exitCode() = %d, want %d", got, tt.wantExit)
+			}
+			if scan.output.Len() != 0 {
+				t.Errorf("diagnostics written: %q", scan.output.String())
+			}
+		})
+	}
+}
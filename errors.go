@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// Error codes automation can act on: retry, page the platform team or ignore
+const (
+	errForbidden       = "FORBIDDEN"
+	errTimeout         = "TIMEOUT"
+	errNotFound        = "NOT_FOUND"
+	errDiscoveryFailed = "DISCOVERY_FAILED"
+	errBudget          = "BUDGET_EXHAUSTED"
+	errInternal        = "INTERNAL"
+)
+
+type scanError struct {
+	Code      string `json:"code"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Message   string `json:"message"`
+
+	// Whether part of the cluster went unscanned because of it
+	Partial bool `json:"partial"`
+}
+
+func (e scanError) String() string {
+	location := e.Resource
+	if e.Namespace != "" {
+		location += " in namespace " + e.Namespace
+	}
+
+	return fmt.Sprintf("%s %s: %s", e.Code, location, e.Message)
+}
+
+func classifyError(err error) string {
+	var netErr net.Error
+	switch {
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err):
+		return errForbidden
+	case apierrors.IsTimeout(err), apierrors.IsServerTimeout(err), apierrors.IsTooManyRequests(err),
+		errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errTimeout
+	case errors.Is(err, errBudgetExhausted):
+		return errBudget
+	case meta.IsNoMatchError(err):
+		return errDiscoveryFailed
+	case apierrors.IsNotFound(err):
+		// Also what listing a resource whose CRD is not installed returns
+		return errNotFound
+	}
+
+	return errInternal
+}
+
+func (scan *scanContext) recordError(resource, namespace string, err error, partial bool) {
+	scan.errors = append(scan.errors, scanError{
+		Code:      classifyError(err),
+		Resource:  resource,
+		Namespace: namespace,
+		Message:   err.Error(),
+		Partial:   partial,
+	})
+}
+
+func (scan *scanContext) reportErrors() {
+	if len(scan.errors) == 0 {
+		return
+	}
+
+	partial := false
+	fmt.Println("Scan errors:")
+	for _, e := range scan.errors {
+		fmt.Printf("  %s\n", e)
+		partial = partial || e.Partial
+	}
+	if partial {
+		fmt.Println("Partial report: some objects could not be scanned")
+	}
+}
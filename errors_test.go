@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestClassifyError(t *testing.T) {
+	secrets := schema.GroupResource{Resource: "secrets"}
+	gateways := schema.GroupResource{Group: "networking.istio.io", Resource: "gateways"}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"forbidden", apierrors.NewForbidden(secrets, "cert", errors.New("RBAC denied")), errForbidden},
+		{"unauthorized", apierrors.NewUnauthorized("token expired"), errForbidden},
+		{"wrapped forbidden", fmt.Errorf("listing secrets: %w", apierrors.NewForbidden(secrets, "", errors.New("RBAC denied"))), errForbidden},
+		{"timeout", apierrors.NewTimeoutError("request timed out", 1), errTimeout},
+		{"server timeout", apierrors.NewServerTimeout(secrets, "list", 1), errTimeout},
+		{"too many requests", apierrors.NewTooManyRequests("slow down", 1), errTimeout},
+		{"deadline exceeded", fmt.Errorf("scan: %w", context.DeadlineExceeded), errTimeout},
+		{"network timeout", &net.OpError{Op: "dial", Err: timeoutError{}}, errTimeout},
+		{"not found", apierrors.NewNotFound(secrets, "cert"), errNotFound},
+		{"crd missing", apierrors.NewNotFound(gateways, ""), errNotFound},
+		{"no kind match", &meta.NoKindMatchError{GroupKind: schema.GroupKind{Group: "gateway.networking.k8s.io", Kind: "Gateway"}}, errDiscoveryFailed},
+		{"budget exhausted", fmt.Errorf("%w at 90 of 100 requests", errBudgetExhausted), errBudget},
+		{"internal error", apierrors.NewInternalError(errors.New("etcd unavailable")), errInternal},
+		{"conflict", apierrors.NewConflict(secrets, "cert", errors.New("changed")), errInternal},
+		{"plain error", errors.New("unexpected"), errInternal},
+		{"network error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, errInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRecordError(t *testing.T) {
+	tests := []struct {
+		name        string
+		partial     []bool
+		wantPartial bool
+	}{
+		{name: "no errors"},
+		{name: "not partial", partial: []bool{false}},
+		{name: "one partial", partial: []bool{false, true}, wantPartial: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scan := &scanContext{}
+			for _, partial := range tt.partial {
+				scan.recordError("secrets", "apps", apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "cert", errors.New("RBAC denied")), partial)
+			}
+			r := newReport(scan, nil)
+			if r.Partial != tt.wantPartial {
+				t.Errorf("report partial = %v, want %v", r.Partial, tt.wantPartial)
+			}
+			for _, e := range r.Errors {
+				if e.Code != errForbidden || e.Resource != "secrets" || e.Namespace != "apps" {
+					t.Errorf("recorded error = %+v", e)
+				}
+			}
+		})
+	}
+}
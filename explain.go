@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	certificateResource = schema.GroupVersionResource{
+		Group:    "cert-manager.io",
+		Version:  "v1",
+		Resource: "certificates",
+	}
+
+	externalSecretResource = schema.GroupVersionResource{
+		Group:    "external-secrets.io",
+		Version:  "v1beta1",
+		Resource: "externalsecrets",
+	}
+)
+
+func explain(kclient *kubernetes.Clientset, dclient dynamic.Interface, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: check-secrets explain gateway|secret <namespace>/<name>")
+	}
+
+	ns, name, ok := strings.Cut(args[1], "/")
+	if !ok || ns == "" || name == "" {
+		return fmt.Errorf("invalid object %q, expected <namespace>/<name>", args[1])
+	}
+
+	switch args[0] {
+	case "gateway", "gw":
+		return explainGateway(kclient, dclient, ns, name)
+	case "secret":
+		return explainSecret(kclient, dclient, ns, name)
+	default:
+		return fmt.Errorf("unknown object kind %q, expected gateway or secret", args[0])
+	}
+}
+
+func explainGateway(kclient *kubernetes.Clientset, dclient dynamic.Interface, ns, name string) error {
+	gw, err := dclient.Resource(gatewayResource).Namespace(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting gateway %s in namespace %s: %v", name, ns, err)
+	}
+
+	fmt.Printf("Gateway %s/%s\n", ns, name)
+
+	// Resolve the workload the gateway configures
+	selector, _, _ := unstructured.NestedStringMap(gw.Object, "spec", "selector")
+	fmt.Printf("  Selector: %s\n", labels.SelectorFromSet(selector).String())
+
+	var workloadNs []string
+	pods, err := getGatewayPods(kclient, *gw)
+	if err != nil {
+		fmt.Printf("  Workload: %v\n", err)
+	} else {
+		seen := map[string]bool{}
+		for _, pod := range pods {
+			if !seen[pod.Namespace] {
+				seen[pod.Namespace] = true
+				workloadNs = append(workloadNs, pod.Namespace)
+			}
+		}
+		sort.Strings(workloadNs)
+		fmt.Printf("  Workload: %d pods in namespace(s) %s\n", len(pods), strings.Join(workloadNs, ", "))
+	}
+
+	servers, found, err := unstructured.NestedSlice(gw.Object, "spec", "servers")
+	if !found || err != nil {
+		return fmt.Errorf("error getting gateway servers: %v", err)
+	}
+
+	for i, serverObj := range servers {
+		server, ok := serverObj.(map[string]interface{})
+		if !ok {
+			fmt.Printf("  Server %d: invalid server object\n", i)
+			continue
+		}
+
+		port, _, _ := unstructured.NestedInt64(server, "port", "number")
+		protocol, _, _ := unstructured.NestedString(server, "port", "protocol")
+		hosts, _, _ := unstructured.NestedStringSlice(server, "hosts")
+		fmt.Printf("  Server %d: port %d (%s), hosts %s\n", i, port, protocol, strings.Join(hosts, ", "))
+
+		tls, found, err := unstructured.NestedMap(server, "tls")
+		if !found || err != nil {
+			fmt.Println("    No TLS configuration")
+			continue
+		}
+
+		mode, _, _ := unstructured.NestedString(tls, "mode")
+		fmt.Printf("    TLS mode: %s\n", mode)
+		if mode == "PASSTHROUGH" {
+			fmt.Println("    Skipped: TLS is terminated by the backend, not the gateway")
+			continue
+		}
+
+		credentialName, _, _ := unstructured.NestedString(tls, "credentialName")
+		if credentialName == "" {
+			serverCert, _, _ := unstructured.NestedString(tls, "serverCertificate")
+			fmt.Printf("    Certificate read from file %q mounted in the gateway pods\n", serverCert)
+			continue
+		}
+
+		fmt.Printf("    credentialName %s looked up in namespace %s (the gateway's namespace)\n", credentialName, ns)
+		for _, wns := range workloadNs {
+			if wns != ns {
+				fmt.Printf("    Note: the gateway workload runs in namespace(s) %s, where Istio resolves credentialName\n", strings.Join(workloadNs, ", "))
+				break
+			}
+		}
+
+		secret, err := kclient.CoreV1().Secrets(ns).Get(context.TODO(), credentialName, metav1.GetOptions{})
+		if err != nil {
+			fmt.Printf("    Problem: error getting secret %s in namespace %s: %v\n", credentialName, ns, err)
+			continue
+		}
+
+		explainSecretData(dclient, *secret, hosts, "    ")
+	}
+
+	return nil
+}
+
+func explainSecret(kclient *kubernetes.Clientset, dclient dynamic.Interface, ns, name string) error {
+	secret, err := kclient.CoreV1().Secrets(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting secret %s in namespace %s: %v", name, ns, err)
+	}
+
+	uses, err := secretReferrers(kclient, dclient, *secret)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Secret %s/%s\n", ns, name)
+	if len(uses) == 0 {
+		fmt.Println("  Not referenced by any gateway or ingress")
+	}
+	var hosts []string
+	for _, use := range uses {
+		for _, ref := range use.refs {
+			fmt.Printf("  Referenced by %s\n", ref)
+		}
+		hosts = append(hosts, use.hosts...)
+	}
+
+	explainSecretData(dclient, *secret, hosts, "  ")
+
+	return nil
+}
+
+// Same lookups as a scan of the secret's namespace, for the enabled --sources
+func secretReferrers(kclient *kubernetes.Clientset, dclient dynamic.Interface, secret corev1.Secret) ([]secretUse, error) {
+	enabled, err := parseSources(*sources)
+	if err != nil {
+		return nil, err
+	}
+	ns := secret.Namespace
+	lookup := newSecretLookup(kclient)
+	lookup.secrets[ns+"/"+secret.Name] = &secret
+
+	var uses []secretUse
+	if enabled[sourceIstio] {
+		gwList, err := listObjects(dclient.Resource(gatewayResource).Namespace(ns), metav1.ListOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("error listing gateways in namespace %s: %v", ns, err)
+		}
+		for _, gw := range gwList.Items {
+			secrets, _, err := getGatewaySecrets(lookup, gw)
+			if err != nil {
+				fmt.Printf("warning: error getting secrets for gateway %s: %v\n", gw.GetName(), err)
+				continue
+			}
+			uses = append(uses, istioGatewayUses(gw, secrets)...)
+		}
+	}
+
+	if enabled[sourceGatewayAPI] {
+		gwList, err := listObjects(dclient.Resource(kubeGatewayResource).Namespace(ns), metav1.ListOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("error listing Gateway API gateways in namespace %s: %v", ns, err)
+		}
+		for _, gw := range gwList.Items {
+			found, _, err := kubeGatewaySecrets(os.Stdout, lookup, dclient, gw)
+			if err != nil {
+				fmt.Printf("warning: error getting secrets for Gateway API gateway %s: %v\n", gw.GetName(), err)
+				continue
+			}
+			uses = append(uses, found...)
+		}
+	}
+
+	if enabled[sourceIngress] {
+		ingList, err := listIngresses(kclient, ns, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error listing ingresses in namespace %s: %v", ns, err)
+		}
+		for _, ing := range ingList.Items {
+			found, _, err := ingressSecrets(lookup, ing)
+			if err != nil {
+				fmt.Printf("warning: error getting secrets for ingress %s: %v\n", ing.Name, err)
+				continue
+			}
+			uses = append(uses, found...)
+		}
+	}
+
+	// Gateways also load their other secrets, only this one's uses are kept
+	var referrers []secretUse
+	for _, use := range uses {
+		if use.secret.Namespace == ns && use.secret.Name == secret.Name {
+			referrers = append(referrers, use)
+		}
+	}
+
+	return referrers, nil
+}
+
+func explainSecretData(dclient dynamic.Interface, secret corev1.Secret, hosts []string, indent string) {
+	fmt.Printf("%sSecret %s/%s (type %s, last modified %s)\n", indent, secret.Namespace, secret.Name, secret.Type, certs.LastModified(secret).UTC().Format(opensslTimeFormat))
+	explainManager(dclient, secret, indent)
+
+	chain, err := certs.SecretCertificates(secret)
+	if err != nil {
+		fmt.Printf("%sProblem: %v\n", indent, err)
+		return
+	}
+
+	printChain(os.Stdout, chain, indent)
+	fmt.Printf("%sSANs: %s\n", indent, strings.Join(chain[0].DNSNames, ", "))
+
+	// Report host coverage and anything that would break clients
+	now := clock()
+	for i, cert := range chain {
+		if now.After(cert.NotAfter) {
+			fmt.Printf("%sProblem: certificate %d expired on %s\n", indent, i, cert.NotAfter.UTC().Format(opensslTimeFormat))
+		}
+	}
+
+	for _, host := range hosts {
+		_, name, err := parseGatewayHost(host)
+		if err != nil {
+			fmt.Printf("%sProblem: malformed host %q: %v\n", indent, host, err)
+			continue
+		}
+		if name == "*" {
+			fmt.Printf("%sHost %s: matches any SNI, not checked against the SANs\n", indent, host)
+		} else if hostCovered(host, chain[0]) {
+			fmt.Printf("%sHost %s: covered by the certificate SANs\n", indent, host)
+		} else {
+			fmt.Printf("%sProblem: host %s not covered by the certificate SANs\n", indent, host)
+		}
+	}
+
+	if err := certs.MatchPrivateKey(secret, chain[0]); err != nil {
+		fmt.Printf("%sProblem: private key: %v\n", indent, err)
+	}
+
+	// Gateways not using MUTUAL keep the issuing CA there, the bundle then verifies the chain
+	if err := certs.VerifyChain(secret, chain, now); err != nil {
+		fmt.Printf("%sCertificate %v, a problem unless the bundle only verifies clients\n", indent, err)
+	}
+
+	if caData, ok := secret.Data["ca.crt"]; ok {
+		caCerts, err := certs.ParseCertificates(caData)
+		if err != nil {
+			fmt.Printf("%sProblem: ca.crt: %v\n", indent, err)
+		} else {
+			fmt.Printf("%sClient CA bundle (ca.crt): %d certificates\n", indent, len(caCerts))
+		}
+	}
+}
+
+func explainManager(dclient dynamic.Interface, secret corev1.Secret, indent string) {
+	switch manager := detectManager(secret, customManagers); manager {
+	case "cert-manager", "external-secrets":
+		// Described below with the managing resource's status
+	default:
+		fmt.Printf("%sManaged by %s\n", indent, manager)
+	}
+
+	if certName := secret.Annotations["cert-manager.io/certificate-name"]; certName != "" {
+		cert, err := dclient.Resource(certificateResource).Namespace(secret.Namespace).Get(context.TODO(), certName, metav1.GetOptions{})
+		if err != nil {
+			fmt.Printf("%sManaged by cert-manager Certificate %s: %v\n", indent, certName, err)
+		} else {
+			fmt.Printf("%sManaged by cert-manager Certificate %s: %s\n", indent, certName, describeConditions(*cert))
+		}
+	}
+
+	for _, owner := range secret.OwnerReferences {
+		if owner.Kind != "ExternalSecret" {
+			continue
+		}
+
+		es, err := dclient.Resource(externalSecretResource).Namespace(secret.Namespace).Get(context.TODO(), owner.Name, metav1.GetOptions{})
+		if err != nil {
+			fmt.Printf("%sManaged by ExternalSecret %s: %v\n", indent, owner.Name, err)
+		} else {
+			fmt.Printf("%sManaged by ExternalSecret %s: %s\n", indent, owner.Name, describeConditions(*es))
+		}
+	}
+}
+
+func describeConditions(obj unstructured.Unstructured) string {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if len(conditions) == 0 {
+		return "no status conditions"
+	}
+
+	var parts []string
+	for _, condObj := range conditions {
+		cond, ok := condObj.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		condType, _, _ := unstructured.NestedString(cond, "type")
+		status, _, _ := unstructured.NestedString(cond, "status")
+		message, _, _ := unstructured.NestedString(cond, "message")
+		part := condType + "=" + status
+		if message != "" {
+			part += " (" + message + ")"
+		}
+		parts = append(parts, part)
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+// Serves one namespace whose Istio gateway, Gateway API gateway and ingresses
+// share a secret, recording every path requested
+func referrersServer(t *testing.T) (*httptest.Server, func() []string) {
+	istioGateway := map[string]interface{}{
+		"apiVersion": gatewayResource.GroupVersion().String(), "kind": "Gateway",
+		"metadata": map[string]interface{}{"name": "istio-gw", "namespace": "apps"},
+		"spec": map[string]interface{}{"servers": []interface{}{
+			map[string]interface{}{"port": map[string]interface{}{"number": 443}, "hosts": []interface{}{"apps/shop.example.com"}, "tls": map[string]interface{}{"mode": "SIMPLE", "credentialName": "shared"}},
+			map[string]interface{}{"port": map[string]interface{}{"number": 8443}, "hosts": []interface{}{"admin.example.com"}, "tls": map[string]interface{}{"mode": "SIMPLE", "credentialName": "other"}},
+		}},
+	}
+	kubeGateway := map[string]interface{}{
+		"apiVersion": kubeGatewayResource.GroupVersion().String(), "kind": "Gateway",
+		"metadata": map[string]interface{}{"name": "kube-gw", "namespace": "apps"},
+		"spec": map[string]interface{}{"listeners": []interface{}{
+			map[string]interface{}{"name": "https", "port": 443, "hostname": "api.example.com", "tls": map[string]interface{}{"certificateRefs": []interface{}{map[string]interface{}{"name": "shared"}}}},
+		}},
+	}
+	ingresses := networkingv1.IngressList{
+		TypeMeta: metav1.TypeMeta{Kind: "IngressList", APIVersion: "networking.k8s.io/v1"},
+		Items: []networkingv1.Ingress{
+			{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"}, Spec: networkingv1.IngressSpec{TLS: []networkingv1.IngressTLS{{SecretName: "shared", Hosts: []string{"www.example.com"}}}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "admin", Namespace: "apps"}, Spec: networkingv1.IngressSpec{TLS: []networkingv1.IngressTLS{{SecretName: "other"}}}},
+		},
+	}
+	list := func(apiVersion string, items ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"apiVersion": apiVersion, "kind": "GatewayList", "metadata": map[string]interface{}{}, "items": items}
+	}
+
+	var (
+		mu    sync.Mutex
+		paths []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+
+		var obj interface{}
+		switch p := r.URL.Path; {
+		case p == "/apis/"+gatewayResource.GroupVersion().String()+"/namespaces/apps/gateways":
+			obj = list(gatewayResource.GroupVersion().String(), istioGateway)
+		case p == "/apis/"+kubeGatewayResource.GroupVersion().String()+"/namespaces/apps/gateways":
+			obj = list(kubeGatewayResource.GroupVersion().String(), kubeGateway)
+		case p == "/apis/networking.k8s.io/v1/namespaces/apps/ingresses":
+			obj = ingresses
+		case strings.HasPrefix(p, "/api/v1/namespaces/apps/secrets/"):
+			name := strings.TrimPrefix(p, "/api/v1/namespaces/apps/secrets/")
+			obj = corev1.Secret{TypeMeta: metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"}, ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps"}}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(obj)
+	}))
+
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), paths...)
+	}
+}
+
+func TestSecretReferrers(t *testing.T) {
+	defer func(value string) { *sources = value }(*sources)
+
+	tests := []struct {
+		name      string
+		sources   string
+		wantRefs  []string
+		wantHosts []string
+	}{
+		{
+			name:      "every source",
+			sources:   "istio,gateway-api,ingress",
+			wantRefs:  []string{"gateway istio-gw server port 443", "Gateway API gateway kube-gw listener https port 443", "ingress web"},
+			wantHosts: []string{"shop.example.com", "api.example.com", "www.example.com"},
+		},
+		{
+			name:      "istio only",
+			sources:   "istio",
+			wantRefs:  []string{"gateway istio-gw server port 443"},
+			wantHosts: []string{"shop.example.com"},
+		},
+		{
+			name:      "ingress only",
+			sources:   "ingress",
+			wantRefs:  []string{"ingress web"},
+			wantHosts: []string{"www.example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, paths := referrersServer(t)
+			defer srv.Close()
+			kclient, dclient, err := newClients(&rest.Config{Host: srv.URL, QPS: -1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			*sources = tt.sources
+
+			secret := corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "apps"}}
+			uses, err := secretReferrers(kclient, dclient, secret)
+			if err != nil {
+				t.Fatalf("secretReferrers() error = %v", err)
+			}
+
+			var refs, hosts []string
+			for _, use := range uses {
+				refs = append(refs, use.refs...)
+				hosts = append(hosts, use.hosts...)
+			}
+			if strings.Join(refs, "; ") != strings.Join(tt.wantRefs, "; ") {
+				t.Errorf("refs = %q, want %q", refs, tt.wantRefs)
+			}
+			if strings.Join(hosts, ",") != strings.Join(tt.wantHosts, ",") {
+				t.Errorf("hosts = %v, want %v", hosts, tt.wantHosts)
+			}
+
+			// Only the secret's namespace is listed, and the secret itself is not fetched again
+			for _, p := range paths() {
+				if strings.HasSuffix(p, "/gateways") && !strings.Contains(p, "/namespaces/apps/") {
+					t.Errorf("cluster-wide list %s", p)
+				}
+				if p == "/api/v1/namespaces/apps/secrets/shared" {
+					t.Errorf("secret fetched again")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type exportEntry struct {
+	Cluster      string   `json:"cluster,omitempty"`
+	File         string   `json:"file"`
+	Namespace    string   `json:"namespace"`
+	Secret       string   `json:"secret"`
+	Gateways     []string `json:"gateways"`
+	Certificates int      `json:"certificates"`
+}
+
+type certExporter struct {
+	mu       sync.Mutex
+	dir      string
+	chain    bool
+	dryRun   bool
+	files    map[string]bool
+	bySecret map[string]*exportEntry
+}
+
+func newCertExporter(dir string, chain, dryRun bool) (*certExporter, error) {
+	if !dryRun {
+		err := os.MkdirAll(dir, 0o700)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create export directory %s: %v", dir, err)
+		}
+	}
+
+	return &certExporter{
+		dir:      dir,
+		chain:    chain,
+		dryRun:   dryRun,
+		files:    map[string]bool{},
+		bySecret: map[string]*exportEntry{},
+	}, nil
+}
+
+func (e *certExporter) export(cluster string, secret corev1.Secret, gateway string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key := cluster + "/" + secret.Namespace + "/" + secret.Name
+	if entry, ok := e.bySecret[key]; ok {
+		// Secret shared by several gateways, exported once
+		entry.Gateways = append(entry.Gateways, gateway)
+		return nil
+	}
+
+	chain, err := certs.SecretCertificates(secret)
+	if err != nil {
+		return err
+	}
+	if !e.chain {
+		chain = chain[:1]
+	}
+
+	// Re-encode the parsed certificates so no other PEM block (e.g. a key) can leak
+	var data []byte
+	for _, cert := range chain {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	// Disambiguate names colliding once namespace and secret are joined
+	base := secret.Namespace + "_" + secret.Name
+	file := base + ".pem"
+	for i := 2; e.files[file]; i++ {
+		file = fmt.Sprintf("%s-%d.pem", base, i)
+	}
+
+	if e.dryRun {
+		fmt.Fprintf(os.Stderr, "dry-run: would write %s with %d certificates\n", filepath.Join(e.dir, file), len(chain))
+	} else {
+		err = os.WriteFile(filepath.Join(e.dir, file), data, 0o600)
+		if err != nil {
+			return fmt.Errorf("unable to write %s: %v", file, err)
+		}
+	}
+
+	e.files[file] = true
+	e.bySecret[key] = &exportEntry{
+		Cluster:      cluster,
+		File:         file,
+		Namespace:    secret.Namespace,
+		Secret:       secret.Name,
+		Gateways:     []string{gateway},
+		Certificates: len(chain),
+	}
+
+	return nil
+}
+
+func (e *certExporter) writeManifest() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var entries []*exportEntry
+	for _, entry := range e.bySecret {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].File < entries[j].File })
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode manifest: %v", err)
+	}
+
+	if e.dryRun {
+		fmt.Fprintf(os.Stderr, "dry-run: would write %s with %d entries\n", filepath.Join(e.dir, "manifest.json"), len(entries))
+		return nil
+	}
+
+	err = os.WriteFile(filepath.Join(e.dir, "manifest.json"), append(data, '\n'), 0o600)
+	if err != nil {
+		return fmt.Errorf("unable to write manifest: %v", err)
+	}
+
+	return nil
+}
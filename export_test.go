@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCertExporterDryRun(t *testing.T) {
+	var secret corev1.Secret
+	for _, tc := range selfTestCases(time.Now()) {
+		if tc.secret == "valid" {
+			built, err := tc.build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			secret = *built
+		}
+	}
+
+	tests := []struct {
+		name      string
+		dryRun    bool
+		wantFiles []string
+	}{
+		{name: "dry run", dryRun: true},
+		{name: "written", dryRun: false, wantFiles: []string{"manifest.json", selfTestNamespace + "_valid.pem"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "export")
+			e, err := newCertExporter(dir, true, tt.dryRun)
+			if err != nil {
+				t.Fatalf("newCertExporter() error = %v", err)
+			}
+			if err := e.export("", secret, selfTestNamespace+"/self-test"); err != nil {
+				t.Fatalf("export() error = %v", err)
+			}
+			if err := e.writeManifest(); err != nil {
+				t.Fatalf("writeManifest() error = %v", err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if tt.dryRun {
+				if !os.IsNotExist(err) {
+					t.Errorf("dry run created the export directory with %d files (error %v)", len(entries), err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files []string
+			for _, entry := range entries {
+				files = append(files, entry.Name())
+			}
+			if len(files) != len(tt.wantFiles) || files[0] != tt.wantFiles[0] || files[1] != tt.wantFiles[1] {
+				t.Errorf("exported %v, want %v", files, tt.wantFiles)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+type certMatch struct {
+	secret   corev1.Secret
+	leaf     *x509.Certificate
+	gateways []string
+}
+
+// Secrets already holding a certificate for every host, to reuse instead of requesting a new one
+func findCertificates(kclient *kubernetes.Clientset, dclient dynamic.Interface, nsList []string, hosts []string) error {
+	if len(hosts) == 0 {
+		return fmt.Errorf("at least one --host is required")
+	}
+	for _, host := range hosts {
+		if _, _, err := parseGatewayHost(host); err != nil {
+			return fmt.Errorf("invalid host %q: %v", host, err)
+		}
+	}
+
+	// Gateways are only shown, a cluster without Istio can still be searched
+	users := map[string][]string{}
+	rows, err := collectSecrets(kclient, dclient, nsList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: unable to list gateways, usage not shown: %v\n", err)
+	}
+	for _, row := range rows {
+		for _, gw := range row.gateways {
+			key := row.namespace + "/" + row.secret.Name
+			users[key] = append(users[key], gw.GetName())
+		}
+	}
+
+	var matches []certMatch
+	for _, ns := range nsList {
+		secrets, err := listSecrets(kclient, ns, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("error listing secrets in namespace %s: %v", ns, err)
+		}
+
+		for _, secret := range secrets.Items {
+			// Anything without a parseable certificate can't be reused anyway
+			chain, err := certs.SecretCertificates(secret)
+			if err != nil {
+				continue
+			}
+
+			covered := true
+			for _, host := range hosts {
+				covered = covered && hostCovered(host, chain[0])
+			}
+			if covered {
+				matches = append(matches, certMatch{secret: secret, leaf: chain[0], gateways: users[ns+"/"+secret.Name]})
+			}
+		}
+	}
+
+	// Longest remaining validity first
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].leaf.NotAfter.After(matches[j].leaf.NotAfter) })
+
+	if len(matches) == 0 {
+		fmt.Printf("No certificate covers %s\n", strings.Join(hosts, ", "))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tSECRET\tEXPIRY\tDAYS\tISSUER\tGATEWAYS")
+	for _, m := range matches {
+		gateways := strings.Join(m.gateways, ",")
+		if gateways == "" {
+			gateways = "-"
+		}
+		expired := ""
+		if clock().After(m.leaf.NotAfter) {
+			expired = " (expired)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s%s\t%d\t%s\t%s\n", m.secret.Namespace, m.secret.Name, m.leaf.NotAfter.UTC().Format(time.RFC3339), expired, certs.DaysRemaining(m.leaf.NotAfter, clock()), m.leaf.Issuer.String(), gateways)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Finding codes, one per kind of result
+const (
+	findingCertOK           = "CERT_OK"
+	findingCertExpiring     = "CERT_EXPIRING"
+	findingCertExpired      = "CERT_EXPIRED"
+	findingCertInvalid      = "CERT_INVALID"
+	findingKeyMismatch      = "CERT_KEY_MISMATCH"
+	findingChainInvalid     = "CERT_CHAIN_INVALID"
+	findingHostMismatch     = "CERT_HOST_MISMATCH"
+	findingSecretMissing    = "SECRET_MISSING"
+	findingSecretUnreadable = "SECRET_UNREADABLE"
+	findingContentForbidden = "SECRET_CONTENT_FORBIDDEN"
+)
+
+// What to do about each code, findingHint names the cert-manager Certificate when known
+var findingHints = map[string]string{
+	findingCertOK:           "",
+	findingCertExpiring:     "renew the certificate before it expires",
+	findingCertExpired:      "renew the certificate, clients are already rejecting it",
+	findingCertInvalid:      "store a PEM certificate chain under tls.crt",
+	findingKeyMismatch:      "store the private key issued with the certificate under tls.key",
+	findingChainInvalid:     "add the missing intermediates to tls.crt or fix the CA bundle",
+	findingHostMismatch:     "reissue the certificate with the uncovered hosts as SANs",
+	findingSecretMissing:    "create the secret or fix the reference to it",
+	findingSecretUnreadable: "grant get on the secret or check the API server",
+	findingContentForbidden: "grant get on the secret to check its certificate",
+}
+
+// Codes a new certificate fixes
+var reissueCodes = map[string]bool{
+	findingCertExpiring: true,
+	findingCertExpired:  true,
+	findingKeyMismatch:  true,
+	findingHostMismatch: true,
+}
+
+func findingHint(r result) string {
+	if r.certificate != "" && reissueCodes[r.Code] {
+		return "rotate via cert-manager Certificate " + r.certificate
+	}
+
+	return findingHints[r.Code]
+}
+
+// Bumped whenever the hashed fields change, so old IDs never collide with new ones
+const findingIDVersion = "v1"
+
+// Stable across runs: cluster, referencing objects, namespace, ports, secret and code.
+// Dates, hosts and messages are left out so a renewed certificate keeps its ID.
+func findingID(cluster string, r result) string {
+	var referrers []string
+	for _, by := range r.ReferencedBy {
+		referrers = append(referrers, by.Kind+"/"+by.Name)
+	}
+	sort.Strings(referrers)
+
+	ports := append([]int64(nil), r.Ports...)
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	var portList []string
+	for _, port := range ports {
+		portList = append(portList, strconv.FormatInt(port, 10))
+	}
+
+	secret := r.Secret
+	if r.SecretNamespace != "" {
+		secret = r.SecretNamespace + "/" + secret
+	}
+
+	fields := []string{findingIDVersion, cluster, strings.Join(referrers, ","), r.Namespace, strings.Join(portList, ","), secret, r.Code}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+
+	return findingIDVersion + "-" + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"testing"
+
+	"github.com/ArnauSB/check-secrets/certs"
+)
+
+func TestFindingIDGolden(t *testing.T) {
+	tests := []struct {
+		name    string
+		cluster string
+		r       result
+		want    string
+	}{
+		{
+			name: "istio gateway",
+			r: result{
+				Namespace:    "istio-system",
+				ReferencedBy: []referrer{{Kind: kindIstioGateway, Name: "public"}},
+				Ports:        []int64{443},
+				Secret:       "public-cert",
+				Code:         findingCertExpiring,
+			},
+			want: "v1-80537528d35a6be3",
+		},
+		{
+			name: "dates, hosts and messages left out",
+			r: result{
+				Namespace:     "istio-system",
+				ReferencedBy:  []referrer{{Kind: kindIstioGateway, Name: "public"}},
+				Ports:         []int64{443},
+				Hosts:         []string{"example.com"},
+				Secret:        "public-cert",
+				DaysRemaining: new(int),
+				Status:        statusWarning,
+				Code:          findingCertExpiring,
+				Error:         "renewed",
+			},
+			want: "v1-80537528d35a6be3",
+		},
+		{
+			name:    "cluster and secret namespace",
+			cluster: "prod",
+			r: result{
+				Namespace:       "apps",
+				ReferencedBy:    []referrer{{Kind: kindGateway, Name: "edge"}},
+				Ports:           []int64{8443},
+				Secret:          "edge-cert",
+				SecretNamespace: "certs",
+				Code:            findingCertExpired,
+			},
+			want: "v1-bac19a6ccd5c1887",
+		},
+		{
+			name: "referrers and ports sorted",
+			r: result{
+				Namespace:    "apps",
+				ReferencedBy: []referrer{{Kind: kindIstioGateway, Name: "b"}, {Kind: kindIngress, Name: "a"}},
+				Ports:        []int64{8443, 443},
+				Secret:       "shared",
+				Code:         findingSecretMissing,
+			},
+			want: "v1-70232a07bfbd6b6c",
+		},
+		{
+			name: "same as above in another order",
+			r: result{
+				Namespace:    "apps",
+				ReferencedBy: []referrer{{Kind: kindIngress, Name: "a"}, {Kind: kindIstioGateway, Name: "b"}},
+				Ports:        []int64{443, 8443},
+				Secret:       "shared",
+				Code:         findingSecretMissing,
+			},
+			want: "v1-70232a07bfbd6b6c",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findingID(tt.cluster, tt.r); got != tt.want {
+				t.Errorf("findingID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindingCodes(t *testing.T) {
+	codes := []string{
+		findingCertOK,
+		findingCertExpiring,
+		findingCertExpired,
+		findingCertInvalid,
+		findingKeyMismatch,
+		findingChainInvalid,
+		findingHostMismatch,
+		findingSecretMissing,
+		findingSecretUnreadable,
+		findingContentForbidden,
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if seen[code] {
+			t.Errorf("duplicate finding code %s", code)
+		}
+		seen[code] = true
+		if _, ok := findingHints[code]; !ok {
+			t.Errorf("finding code %s has no hint", code)
+		}
+		if code != findingCertOK && findingHints[code] == "" {
+			t.Errorf("finding code %s has an empty hint", code)
+		}
+	}
+	for code := range findingHints {
+		if !seen[code] {
+			t.Errorf("hint for unknown finding code %s", code)
+		}
+	}
+}
+
+func TestFindingConstructorsSetCode(t *testing.T) {
+	leaf := &x509.Certificate{}
+	finding := func(f certs.Finding) certs.Finding {
+		f.Chain = []*x509.Certificate{leaf}
+		return f
+	}
+
+	tests := []struct {
+		name string
+		set  func(r *result)
+		want string
+	}{
+		{"ok", func(r *result) { r.setFinding(finding(certs.Finding{Status: statusOK})) }, findingCertOK},
+		{"expiring", func(r *result) { r.setFinding(finding(certs.Finding{Status: statusWarning})) }, findingCertExpiring},
+		{"expired", func(r *result) { r.setFinding(finding(certs.Finding{Status: statusExpired})) }, findingCertExpired},
+		{"key mismatch", func(r *result) { r.setFinding(finding(certs.Finding{Status: statusOK, KeyError: "mismatch"})) }, findingKeyMismatch},
+		{"chain invalid", func(r *result) {
+			r.setFinding(finding(certs.Finding{Status: statusOK, ChainError: "unknown authority"}))
+		}, findingChainInvalid},
+		{"host mismatch", func(r *result) {
+			r.setFinding(finding(certs.Finding{Status: statusOK, UncoveredHosts: []string{"a.example.com"}}))
+		}, findingHostMismatch},
+		{"expired wins over key mismatch", func(r *result) { r.setFinding(finding(certs.Finding{Status: statusExpired, KeyError: "mismatch"})) }, findingCertExpired},
+		{"key mismatch wins over chain", func(r *result) {
+			r.setFinding(finding(certs.Finding{Status: statusWarning, KeyError: "mismatch", ChainError: "unknown authority"}))
+		}, findingKeyMismatch},
+		{"error", func(r *result) { r.setError(findingSecretMissing, errors.New("not found"), "") }, findingSecretMissing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r result
+			tt.set(&r)
+			if r.Code != tt.want {
+				t.Errorf("code = %q, want %q", r.Code, tt.want)
+			}
+			if _, ok := findingHints[r.Code]; !ok {
+				t.Errorf("code %q has no hint", r.Code)
+			}
+		})
+	}
+}
+
+func TestFindingHint(t *testing.T) {
+	tests := []struct {
+		name string
+		r    result
+		want string
+	}{
+		{"no manager", result{Code: findingCertExpired}, findingHints[findingCertExpired]},
+		{"cert-manager", result{Code: findingCertExpired, certificate: "web"}, "rotate via cert-manager Certificate web"},
+		{"not fixed by rotating", result{Code: findingSecretMissing, certificate: "web"}, findingHints[findingSecretMissing]},
+		{"ok", result{Code: findingCertOK, certificate: "web"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findingHint(tt.r); got != tt.want {
+				t.Errorf("findingHint() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
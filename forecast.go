@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+type forecastEntry struct {
+	namespace string
+	secret    string
+	notAfter  time.Time
+}
+
+type forecastBucket struct {
+	label   string
+	maxDays int // exclusive upper bound, -1 for the open-ended bucket
+	entries []forecastEntry
+}
+
+func parseForecastBuckets(value string) ([]*forecastBucket, error) {
+	var bounds []int
+	for _, part := range strings.Split(value, ",") {
+		days, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || days <= 0 {
+			return nil, fmt.Errorf("invalid forecast bucket boundary %q", part)
+		}
+		if len(bounds) > 0 && days <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("forecast bucket boundaries must be increasing")
+		}
+		bounds = append(bounds, days)
+	}
+
+	// Expired certificates always get their own bucket
+	buckets := []*forecastBucket{{label: "expired", maxDays: 0}}
+	lower := 0
+	for _, days := range bounds {
+		label := fmt.Sprintf("%d-%dd", lower, days)
+		if lower == 0 {
+			label = fmt.Sprintf("<%dd", days)
+		}
+		buckets = append(buckets, &forecastBucket{label: label, maxDays: days})
+		lower = days
+	}
+	buckets = append(buckets, &forecastBucket{label: fmt.Sprintf(">%dd", lower), maxDays: -1})
+
+	return buckets, nil
+}
+
+func (b *forecastBucket) holds(notAfter time.Time) bool {
+	if b.maxDays < 0 {
+		return true
+	}
+	if b.maxDays == 0 {
+		return clock().After(notAfter)
+	}
+
+	return notAfter.Sub(clock()) < time.Duration(b.maxDays)*24*time.Hour
+}
+
+func forecast(kclient *kubernetes.Clientset, dclient dynamic.Interface, nsList []string, bucketSpec string, verbose bool) error {
+	buckets, err := parseForecastBuckets(bucketSpec)
+	if err != nil {
+		return err
+	}
+
+	rows, err := collectSecrets(kclient, dclient, nsList)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		chain, err := certs.SecretCertificates(row.secret)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error analyzing certificate %s in namespace %s: %v\n", row.secret.Name, row.namespace, err)
+			continue
+		}
+
+		// Buckets are ordered, the first one holding the date wins
+		entry := forecastEntry{namespace: row.namespace, secret: row.secret.Name, notAfter: chain[0].NotAfter}
+		for _, bucket := range buckets {
+			if bucket.holds(entry.notAfter) {
+				bucket.entries = append(bucket.entries, entry)
+				break
+			}
+		}
+	}
+
+	fmt.Println("Expiry forecast:")
+	for _, bucket := range buckets {
+		fmt.Printf("  %-10s %d\n", bucket.label, len(bucket.entries))
+
+		if !verbose {
+			continue
+		}
+		sort.Slice(bucket.entries, func(i, j int) bool {
+			return bucket.entries[i].notAfter.Before(bucket.entries[j].notAfter)
+		})
+		for _, entry := range bucket.entries {
+			fmt.Printf("    %s %s/%s\n", entry.notAfter.UTC().Format(time.RFC3339), entry.namespace, entry.secret)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseForecastBuckets(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []string
+		wantErr string
+	}{
+		{spec: "30", want: []string{"expired", "<30d", ">30d"}},
+		{spec: "7,30,90", want: []string{"expired", "<7d", "7-30d", "30-90d", ">90d"}},
+		{spec: " 7, 30 ", want: []string{"expired", "<7d", "7-30d", ">30d"}},
+		{spec: "", wantErr: "invalid forecast bucket boundary"},
+		{spec: "7,,30", wantErr: "invalid forecast bucket boundary"},
+		{spec: "7,x", wantErr: `invalid forecast bucket boundary "x"`},
+		{spec: "0,30", wantErr: "invalid forecast bucket boundary"},
+		{spec: "-7", wantErr: "invalid forecast bucket boundary"},
+		{spec: "30,7", wantErr: "forecast bucket boundaries must be increasing"},
+		{spec: "7,7", wantErr: "forecast bucket boundaries must be increasing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			buckets, err := parseForecastBuckets(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseForecastBuckets(%q) error = %v, want %q", tt.spec, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseForecastBuckets(%q) error = %v", tt.spec, err)
+			}
+			var labels []string
+			for _, b := range buckets {
+				labels = append(labels, b.label)
+			}
+			if strings.Join(labels, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("parseForecastBuckets(%q) = %v, want %v", tt.spec, labels, tt.want)
+			}
+		})
+	}
+}
+
+func TestForecastBucketHolds(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock = func() time.Time { return now }
+	defer func() { clock = time.Now }()
+
+	buckets, err := parseForecastBuckets("7,30")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		notAfter time.Time
+		want     string
+	}{
+		{"expired", now.Add(-time.Second), "expired"},
+		{"expires now", now, "<7d"},
+		{"just under a week", now.AddDate(0, 0, 7).Add(-time.Second), "<7d"},
+		{"exactly a week", now.AddDate(0, 0, 7), "7-30d"},
+		{"exactly 30 days", now.AddDate(0, 0, 30), ">30d"},
+		{"next year", now.AddDate(1, 0, 0), ">30d"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Buckets are tried in order, the first holding the certificate wins
+			for _, b := range buckets {
+				if b.holds(tt.notAfter) {
+					if b.label != tt.want {
+						t.Errorf("certificate expiring %s in bucket %s, want %s", tt.notAfter, b.label, tt.want)
+					}
+					return
+				}
+			}
+			t.Errorf("no bucket holds a certificate expiring %s", tt.notAfter)
+		})
+	}
+}
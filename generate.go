@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+type certificateOptions struct {
+	issuer      string
+	duration    string
+	renewBefore string
+	fromHosts   bool
+	outputDir   string
+	dryRun      bool
+}
+
+func gatewayHostsForSecret(gateways []unstructured.Unstructured, secretName string) []string {
+	seen := map[string]bool{}
+	for _, gw := range gateways {
+		servers, _, _ := unstructured.NestedSlice(gw.Object, "spec", "servers")
+		for _, serverObj := range servers {
+			server, ok := serverObj.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			credentialName, _, _ := unstructured.NestedString(server, "tls", "credentialName")
+			if credentialName != secretName {
+				continue
+			}
+
+			hosts, _, _ := unstructured.NestedStringSlice(server, "hosts")
+			for _, host := range hosts {
+				_, name, err := parseGatewayHost(host)
+				if err == nil && name != "*" {
+					seen[name] = true
+				}
+			}
+		}
+	}
+
+	var hosts []string
+	for host := range seen {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	return hosts
+}
+
+func renderCertificate(usage *secretUsage, opts certificateOptions) ([]byte, error) {
+	var dnsNames []string
+	if opts.fromHosts {
+		dnsNames = gatewayHostsForSecret(usage.gateways, usage.secret.Name)
+	} else {
+		chain, err := certs.SecretCertificates(usage.secret)
+		if err != nil {
+			return nil, err
+		}
+		dnsNames = chain[0].DNSNames
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Generated by check-secrets from secret %s/%s\n", usage.secret.Namespace, usage.secret.Name)
+	fmt.Fprintln(&b, "apiVersion: cert-manager.io/v1")
+	fmt.Fprintln(&b, "kind: Certificate")
+	fmt.Fprintln(&b, "metadata:")
+	fmt.Fprintf(&b, "  name: %s\n", usage.secret.Name)
+	fmt.Fprintf(&b, "  namespace: %s\n", usage.secret.Namespace)
+	fmt.Fprintln(&b, "spec:")
+	fmt.Fprintf(&b, "  secretName: %s\n", usage.secret.Name)
+	fmt.Fprintf(&b, "  duration: %s\n", opts.duration)
+	fmt.Fprintf(&b, "  renewBefore: %s\n", opts.renewBefore)
+
+	if len(dnsNames) == 0 {
+		fmt.Fprintln(&b, "  # FIXME: no DNS names could be inferred")
+		fmt.Fprintln(&b, "  dnsNames: []")
+	} else {
+		fmt.Fprintln(&b, "  dnsNames:")
+		for _, name := range dnsNames {
+			fmt.Fprintf(&b, "  - %q\n", name)
+		}
+	}
+
+	// The issuer can't be inferred from the certificate
+	fmt.Fprintln(&b, "  issuerRef:")
+	if opts.issuer == "" {
+		fmt.Fprintln(&b, "    # FIXME: set the Issuer or ClusterIssuer to use")
+		fmt.Fprintln(&b, "    kind: ClusterIssuer")
+		fmt.Fprintln(&b, "    name: ISSUER_NAME")
+	} else {
+		kind, name, ok := strings.Cut(opts.issuer, "/")
+		if !ok {
+			kind, name = "Issuer", opts.issuer
+		}
+		fmt.Fprintf(&b, "    kind: %s\n", kind)
+		fmt.Fprintf(&b, "    name: %s\n", name)
+	}
+	fmt.Fprintln(&b, "    group: cert-manager.io")
+
+	return b.Bytes(), nil
+}
+
+func generateCertificates(kclient *kubernetes.Clientset, dclient dynamic.Interface, nsList []string, args []string, opts certificateOptions) error {
+	if len(args) < 1 || args[0] != "certificate" || len(args) > 2 {
+		return fmt.Errorf("usage: check-secrets generate certificate [<namespace>/<name>]")
+	}
+
+	var usages []*secretUsage
+	if len(args) == 2 {
+		// A named secret is generated even if it is already managed
+		ns, name, ok := strings.Cut(args[1], "/")
+		if !ok {
+			return fmt.Errorf("invalid secret %q, expected <namespace>/<name>", args[1])
+		}
+
+		secret, err := kclient.CoreV1().Secrets(ns).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("error getting secret %s in namespace %s: %v", name, ns, err)
+		}
+
+		gwList, err := listObjects(dclient.Resource(gatewayResource).Namespace(ns), metav1.ListOptions{LabelSelector: *gatewaySelector})
+		if err != nil {
+			return err
+		}
+		usages = append(usages, &secretUsage{namespace: ns, secret: *secret, gateways: gwList.Items})
+	} else {
+		all, err := collectSecrets(kclient, dclient, nsList)
+		if err != nil {
+			return err
+		}
+		for _, usage := range all {
+			if detectManager(usage.secret, customManagers) == unmanaged {
+				usages = append(usages, usage)
+			}
+		}
+	}
+
+	if opts.outputDir != "" && !opts.dryRun {
+		err := os.MkdirAll(opts.outputDir, 0o755)
+		if err != nil {
+			return fmt.Errorf("unable to create output directory %s: %v", opts.outputDir, err)
+		}
+	}
+
+	for i, usage := range usages {
+		manifest, err := renderCertificate(usage, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error generating certificate for secret %s in namespace %s: %v\n", usage.secret.Name, usage.namespace, err)
+			continue
+		}
+
+		if opts.outputDir == "" {
+			if i > 0 {
+				fmt.Println("---")
+			}
+			os.Stdout.Write(manifest)
+			continue
+		}
+
+		file := filepath.Join(opts.outputDir, usage.namespace+"_"+usage.secret.Name+".yaml")
+		if opts.dryRun {
+			fmt.Fprintf(os.Stderr, "dry-run: would write %s\n", file)
+			continue
+		}
+		err = os.WriteFile(file, manifest, 0o644)
+		if err != nil {
+			return fmt.Errorf("unable to write %s: %v", file, err)
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %s\n", file)
+	}
+
+	return nil
+}
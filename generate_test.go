@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+func TestGenerateCertificatesDryRun(t *testing.T) {
+	cases := selfTestCases(time.Now())
+	secrets := map[string]*corev1.Secret{}
+	for _, tc := range cases {
+		if tc.build == nil {
+			continue
+		}
+		secret, err := tc.build()
+		if err != nil {
+			t.Fatalf("building %s: %v", tc.name, err)
+		}
+		secrets[tc.secret] = secret
+	}
+	srv := selfTestServer(cases, secrets)
+	defer srv.Close()
+	kclient, dclient, err := newClients(&rest.Config{Host: srv.URL, QPS: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		dryRun   bool
+		wantFile bool
+	}{
+		{name: "dry run", dryRun: true},
+		{name: "written", dryRun: false, wantFile: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "certificates")
+			err := generateCertificates(kclient, dclient, nil, []string{"certificate", selfTestNamespace + "/valid"}, certificateOptions{
+				duration:    "2160h",
+				renewBefore: "360h",
+				fromHosts:   true,
+				outputDir:   dir,
+				dryRun:      tt.dryRun,
+			})
+			if err != nil {
+				t.Fatalf("generateCertificates() error = %v", err)
+			}
+
+			_, err = os.Stat(filepath.Join(dir, selfTestNamespace+"_valid.yaml"))
+			if tt.wantFile && err != nil {
+				t.Errorf("certificate not written: %v", err)
+			}
+			if !tt.wantFile {
+				if _, err := os.Stat(dir); !os.IsNotExist(err) {
+					t.Errorf("dry run created the output directory (stat error %v)", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Keys Istio reads from gateway secrets, including the legacy generic secret names
+var recognizedSecretKeys = []string{"tls.crt", "tls.key", "ca.crt", "ca.crl", "cert", "key", "cacert"}
+
+func checkSecretHygiene(w io.Writer, secret corev1.Secret, maxSize int, extraKeys []string) {
+	known := map[string]bool{}
+	for _, key := range append(recognizedSecretKeys, extraKeys...) {
+		known[key] = true
+	}
+
+	var keys []string
+	size := 0
+	for key, value := range secret.Data {
+		keys = append(keys, key)
+		size += len(value)
+	}
+	sort.Strings(keys)
+
+	if maxSize > 0 && size > maxSize {
+		fmt.Fprintf(w, "notice: secret %s in namespace %s holds %d bytes of data, over the %d byte limit\n", secret.Name, secret.Namespace, size, maxSize)
+	}
+	for _, key := range keys {
+		if !known[key] {
+			fmt.Fprintf(w, "notice: secret %s in namespace %s has unexpected key %s (%d bytes)\n", secret.Name, secret.Namespace, key, len(secret.Data[key]))
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+type secretUsage struct {
+	namespace string
+	secret    corev1.Secret
+	gateways  []unstructured.Unstructured
+}
+
+func resolveOwner(keys []string, objects ...metav1.Object) string {
+	// Objects are passed in precedence order, labels win over annotations
+	for _, obj := range objects {
+		for _, key := range keys {
+			if owner := obj.GetLabels()[key]; owner != "" {
+				return owner
+			}
+			if owner := obj.GetAnnotations()[key]; owner != "" {
+				return owner
+			}
+		}
+	}
+
+	return "unowned"
+}
+
+func collectSecrets(kclient *kubernetes.Clientset, dclient dynamic.Interface, nsList []string) ([]*secretUsage, error) {
+	// Collect each secret once with every gateway referencing it
+	var rows []*secretUsage
+	for _, ns := range nsList {
+		gwList, err := listObjects(dclient.Resource(gatewayResource).Namespace(ns), metav1.ListOptions{LabelSelector: *gatewaySelector})
+		if err != nil {
+			return nil, err
+		}
+
+		bySecret := map[string]*secretUsage{}
+		for _, gw := range gwList.Items {
+			secrets, missing, err := getGatewaySecrets(newSecretLookup(kclient), gw)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error getting secrets for gateway %s in namespace %s: %v\n", gw.GetName(), ns, err)
+				continue
+			}
+			for _, name := range missing {
+				fmt.Fprintf(os.Stderr, "error getting secret %s for gateway %s in namespace %s: secret not found\n", name, gw.GetName(), ns)
+			}
+
+			for _, secret := range secrets {
+				row, ok := bySecret[secret.Name]
+				if !ok {
+					row = &secretUsage{namespace: ns, secret: secret}
+					bySecret[secret.Name] = row
+					rows = append(rows, row)
+				}
+				row.gateways = append(row.gateways, gw)
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+func inventory(kclient *kubernetes.Clientset, dclient dynamic.Interface, nsList []string, format string, ownerKeys []string) error {
+	nsObjects, err := listNamespaces(kclient, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get the list of namespaces: %v", err)
+	}
+	namespaces := map[string]*corev1.Namespace{}
+	for i := range nsObjects.Items {
+		namespaces[nsObjects.Items[i].Name] = &nsObjects.Items[i]
+	}
+
+	rows, err := collectSecrets(kclient, dclient, nsList)
+	if err != nil {
+		return err
+	}
+
+	header := []string{"NAMESPACE", "SECRET", "GATEWAYS", "OWNER", "ISSUER", "EXPIRY", "DAYS"}
+	var records [][]string
+	for _, row := range rows {
+		var gwNames []string
+		owners := []metav1.Object{&row.secret}
+		for i := range row.gateways {
+			gwNames = append(gwNames, row.gateways[i].GetName())
+			owners = append(owners, &row.gateways[i])
+		}
+		if ns, ok := namespaces[row.namespace]; ok {
+			owners = append(owners, ns)
+		}
+
+		issuer, expiry, days := "", "", ""
+		chain, err := certs.SecretCertificates(row.secret)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error analyzing certificate %s in namespace %s: %v\n", row.secret.Name, row.namespace, err)
+		} else {
+			issuer = chain[0].Issuer.String()
+			expiry = chain[0].NotAfter.UTC().Format(time.RFC3339)
+			days = strconv.Itoa(certs.DaysRemaining(chain[0].NotAfter, clock()))
+		}
+
+		records = append(records, []string{row.namespace, row.secret.Name, strings.Join(gwNames, ";"), resolveOwner(ownerKeys, owners...), issuer, expiry, days})
+	}
+
+	switch format {
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write(header)
+		w.WriteAll(records)
+		return w.Error()
+	case "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+		for _, record := range records {
+			fmt.Fprintln(w, strings.Join(record, "\t"))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unsupported inventory output format %q", format)
+	}
+}
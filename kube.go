@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	// Register the oidc auth provider and the removed gcp/azure stubs
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+)
+
+type clientOptions struct {
+	kubeconfig string
+	context    string
+	timeout    time.Duration
+	proxyURL   string
+
+	// Deadline for every request made with the client, zero for none
+	clusterTimeout time.Duration
+
+	// Fails every request once done, nil for none
+	ctx context.Context
+}
+
+func clientConfig(kubeconfig, context string) clientcmd.ClientConfig {
+	// Standard precedence: --kubeconfig, then every path in KUBECONFIG merged, then ~/.kube/config
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+
+	overrides := &clientcmd.ConfigOverrides{
+		ClusterDefaults: clientcmd.ClusterDefaults,
+		CurrentContext:  context,
+	}
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+}
+
+func currentContext(kubeconfig, context string) string {
+	if context != "" {
+		return context
+	}
+
+	rawConfig, err := clientConfig(kubeconfig, context).RawConfig()
+	if err != nil {
+		return ""
+	}
+
+	return rawConfig.CurrentContext
+}
+
+func listContexts(kubeconfig, skip string) ([]string, error) {
+	rawConfig, err := clientConfig(kubeconfig, "").RawConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var contexts []string
+	for name := range rawConfig.Contexts {
+		if skip != "" {
+			if matched, _ := path.Match(skip, name); matched {
+				continue
+			}
+		}
+		contexts = append(contexts, name)
+	}
+	sort.Strings(contexts)
+
+	return contexts, nil
+}
+
+type deadlineTransport struct {
+	next     http.RoundTripper
+	deadline time.Time
+	// Cancels requests in flight when the cluster scan is given up on
+	ctx context.Context
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if !t.deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, t.deadline)
+	}
+	if t.ctx != nil {
+		if err := t.ctx.Err(); err != nil {
+			cancel()
+			return nil, err
+		}
+		scanCtx, cancelScan := context.WithCancel(ctx)
+		stop := context.AfterFunc(t.ctx, cancelScan)
+		ctx = scanCtx
+		release := cancel
+		cancel = func() {
+			stop()
+			cancelScan()
+			release()
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// Release the deadline once the body has been consumed
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func checkExecPlugin(restConfig *rest.Config) error {
+	if restConfig.ExecProvider == nil {
+		return nil
+	}
+
+	// Fail early with an actionable message instead of an opaque transport error
+	command := restConfig.ExecProvider.Command
+	if _, err := exec.LookPath(command); err != nil {
+		msg := fmt.Sprintf("exec plugin %s not found in PATH", command)
+		if hint := restConfig.ExecProvider.InstallHint; hint != "" {
+			msg += ": " + hint
+		}
+		return fmt.Errorf("%s", msg)
+	}
+
+	return nil
+}
+
+func setProxy(restConfig *rest.Config, proxyURL, source string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid proxy URL from %s", source)
+	}
+
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+
+	// Userinfo in the URL is sent as Proxy-Authorization on CONNECT
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  proxyURL,
+		HTTPSProxy: proxyURL,
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+
+	restConfig.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+
+	// The decision only depends on the API server host, log it once
+	if host, err := url.Parse(restConfig.Host); err == nil {
+		if proxy, _ := proxyFunc(host); proxy != nil {
+			debugf("Using proxy %s from %s for cluster %s", u.Redacted(), source, restConfig.Host)
+		} else {
+			debugf("Connecting to cluster %s directly, excluded by NO_PROXY", restConfig.Host)
+		}
+	}
+
+	return nil
+}
+
+func applyTimeouts(restConfig *rest.Config, opts clientOptions) {
+	// Bound every request, including the exec credential helper run it may trigger
+	restConfig.Timeout = opts.timeout
+
+	// Bound the whole scan of the cluster so one dead cluster can't stall the rest
+	if opts.clusterTimeout > 0 || opts.ctx != nil {
+		var deadline time.Time
+		if opts.clusterTimeout > 0 {
+			deadline = time.Now().Add(opts.clusterTimeout)
+		}
+		restConfig.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+			return &deadlineTransport{next: rt, deadline: deadline, ctx: opts.ctx}
+		}
+	}
+}
+
+func restConfig(opts clientOptions) (*rest.Config, error) {
+	clientcfg := clientConfig(opts.kubeconfig, opts.context)
+	restConfig, err := clientcfg.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get k8s config file: %v", err)
+	}
+
+	err = checkExecPlugin(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	applyTimeouts(restConfig, opts)
+
+	// Report which file and context won the merge
+	proxyURL, proxySource := opts.proxyURL, "--proxy-url"
+	rawConfig, err := clientcfg.RawConfig()
+	if err == nil && len(rawConfig.Contexts) > 0 {
+		current := opts.context
+		if current == "" {
+			current = rawConfig.CurrentContext
+		}
+		if kctx, ok := rawConfig.Contexts[current]; ok {
+			fmt.Fprintf(os.Stderr, "Using context %s from %s\n", current, kctx.LocationOfOrigin)
+			if cluster, ok := rawConfig.Clusters[kctx.Cluster]; ok && proxyURL == "" {
+				proxyURL, proxySource = cluster.ProxyURL, "kubeconfig cluster "+kctx.Cluster
+			}
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "Using in-cluster configuration")
+	}
+
+	if proxyURL != "" {
+		err = setProxy(restConfig, proxyURL, proxySource)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		debugf("No proxy configured for cluster %s", restConfig.Host)
+	}
+
+	return restConfig, nil
+}
+
+func k8sClient(opts clientOptions) (*kubernetes.Clientset, dynamic.Interface, error) {
+	restConfig, err := restConfig(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newClients(restConfig)
+}
+
+func clientsForConfig(restConfig *rest.Config, opts clientOptions) (*kubernetes.Clientset, dynamic.Interface, error) {
+	err := checkExecPlugin(restConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	applyTimeouts(restConfig, opts)
+
+	if opts.proxyURL != "" {
+		err = setProxy(restConfig, opts.proxyURL, "--proxy-url")
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return newClients(restConfig)
+}
+
+func newClients(restConfig *rest.Config) (*kubernetes.Clientset, dynamic.Interface, error) {
+	// Count every request, including discovery and retries
+	restConfig.Wrap(apiBudget.wrap)
+	if *printAccessSummary {
+		restConfig.Wrap(apiAccess.wrap)
+	}
+
+	k8sClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create k8s client: %v", err)
+	}
+
+	k8sDynClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create k8s dynamic client: %v", err)
+	}
+
+	return k8sClient, newServedVersionClient(k8sDynClient, k8sClient.Discovery()), nil
+}
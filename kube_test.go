@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// A kubeconfig with one cluster, user and context per name, the first one current
+func writeKubeconfig(t *testing.T, dir, file string, current string, contexts ...string) string {
+	t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "apiVersion: v1\nkind: Config\n")
+	if current != "" {
+		fmt.Fprintf(&b, "current-context: %s\n", current)
+	}
+	b.WriteString("clusters:\n")
+	for _, name := range contexts {
+		fmt.Fprintf(&b, "- name: %s\n  cluster:\n    server: https://%s.%s.example.com\n", name, name, file)
+	}
+	b.WriteString("users:\n")
+	for _, name := range contexts {
+		fmt.Fprintf(&b, "- name: %s\n  user:\n    token: %s\n", name, name)
+	}
+	b.WriteString("contexts:\n")
+	for _, name := range contexts {
+		fmt.Fprintf(&b, "- name: %s\n  context:\n    cluster: %s\n    user: %s\n", name, name, name)
+	}
+
+	p := filepath.Join(dir, file)
+	if err := os.WriteFile(p, []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestKubeconfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	// Never fall back to the kubeconfig of whoever runs the tests
+	t.Setenv("HOME", dir)
+
+	first := writeKubeconfig(t, dir, "first", "dev", "dev", "shared")
+	second := writeKubeconfig(t, dir, "second", "prod", "prod", "shared")
+	explicit := writeKubeconfig(t, dir, "explicit", "ops", "ops")
+	noCurrent := writeKubeconfig(t, dir, "no-current", "", "staging")
+
+	tests := []struct {
+		name         string
+		env          string
+		kubeconfig   string
+		context      string
+		wantContext  string
+		wantHost     string
+		wantContexts []string
+		wantErr      bool
+	}{
+		{
+			name:         "single path",
+			env:          first,
+			wantContext:  "dev",
+			wantHost:     "https://dev.first.example.com",
+			wantContexts: []string{"dev", "shared"},
+		},
+		{
+			name:         "merged paths, first current-context wins",
+			env:          first + string(os.PathListSeparator) + second,
+			wantContext:  "dev",
+			wantHost:     "https://dev.first.example.com",
+			wantContexts: []string{"dev", "prod", "shared"},
+		},
+		{
+			name:         "current-context from a later file",
+			env:          noCurrent + string(os.PathListSeparator) + second,
+			wantContext:  "prod",
+			wantHost:     "https://prod.second.example.com",
+			wantContexts: []string{"prod", "shared", "staging"},
+		},
+		{
+			name:         "first file wins for a duplicate context",
+			env:          first + string(os.PathListSeparator) + second,
+			context:      "shared",
+			wantContext:  "shared",
+			wantHost:     "https://shared.first.example.com",
+			wantContexts: []string{"dev", "prod", "shared"},
+		},
+		{
+			name:         "context overrides current-context",
+			env:          first + string(os.PathListSeparator) + second,
+			context:      "prod",
+			wantContext:  "prod",
+			wantHost:     "https://prod.second.example.com",
+			wantContexts: []string{"dev", "prod", "shared"},
+		},
+		{
+			name:         "kubeconfig overrides KUBECONFIG",
+			env:          first + string(os.PathListSeparator) + second,
+			kubeconfig:   explicit,
+			wantContext:  "ops",
+			wantHost:     "https://ops.explicit.example.com",
+			wantContexts: []string{"ops"},
+		},
+		{
+			name:       "context only looked up in kubeconfig",
+			env:        first,
+			kubeconfig: explicit,
+			context:    "dev",
+			wantErr:    true,
+		},
+		{
+			name:       "missing kubeconfig",
+			env:        first,
+			kubeconfig: filepath.Join(dir, "missing"),
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KUBECONFIG", tt.env)
+
+			config, err := restConfig(clientOptions{kubeconfig: tt.kubeconfig, context: tt.context})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("restConfig() = %s, want an error", config.Host)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("restConfig() error = %v", err)
+			}
+			if config.Host != tt.wantHost {
+				t.Errorf("restConfig() host = %s, want %s", config.Host, tt.wantHost)
+			}
+			if got := currentContext(tt.kubeconfig, tt.context); got != tt.wantContext {
+				t.Errorf("currentContext() = %s, want %s", got, tt.wantContext)
+			}
+			contexts, err := listContexts(tt.kubeconfig, "")
+			if err != nil {
+				t.Fatalf("listContexts() error = %v", err)
+			}
+			if !slices.Equal(contexts, tt.wantContexts) {
+				t.Errorf("listContexts() = %v, want %v", contexts, tt.wantContexts)
+			}
+		})
+	}
+}
+
+func TestContextTargets(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("KUBECONFIG", writeKubeconfig(t, dir, "config", "dev-eu", "dev-eu", "dev-us", "prod-eu", "prod-us"))
+
+	tests := []struct {
+		name    string
+		skip    string
+		only    []string
+		want    []string
+		wantErr string
+	}{
+		{name: "all contexts", want: []string{"dev-eu", "dev-us", "prod-eu", "prod-us"}},
+		{name: "skipped", skip: "dev-*", want: []string{"prod-eu", "prod-us"}},
+		{name: "only, in the given order", only: []string{"prod-us", "dev-eu"}, want: []string{"prod-us", "dev-eu"}},
+		{name: "only a skipped context", skip: "dev-*", only: []string{"dev-eu"}, wantErr: "context dev-eu not found in the kubeconfig or skipped by --skip-contexts"},
+		{name: "only an unknown context", only: []string{"qa"}, wantErr: "context qa not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := contextTargets("", tt.skip, tt.only)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("contextTargets() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("contextTargets() error = %v", err)
+			}
+			var names []string
+			for _, target := range targets {
+				names = append(names, target.name)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("contextTargets() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+// A kubeconfig whose user runs command as an exec credential helper for cluster
+func writeExecKubeconfig(t *testing.T, dir, server, command, cluster string) string {
+	t.Helper()
+	config := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: eks
+clusters:
+- name: eks
+  cluster:
+    server: %s
+    insecure-skip-tls-verify: true
+users:
+- name: eks
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: %s
+      args: [token, --cluster, %s]
+      installHint: install the fake helper
+contexts:
+- name: eks
+  context:
+    cluster: eks
+    user: eks
+`, server, command, cluster)
+
+	p := filepath.Join(dir, "config")
+	if err := os.WriteFile(p, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestExecCredentialPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake credential helper is a shell script")
+	}
+
+	// Credentials are only sent over TLS
+	var gotAuth atomic.Value
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth.Store(r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"kind":"NamespaceList","apiVersion":"v1","metadata":{},"items":[]}`)
+	}))
+	defer srv.Close()
+
+	// Prints a token taken from the user's environment, or fails like a helper without credentials
+	bin := t.TempDir()
+	helper := `#!/bin/sh
+if [ -z "$FAKE_CLOUD_TOKEN" ]; then
+  echo "error: no credentials configured" >&2
+  exit 1
+fi
+printf '{"apiVersion":"client.authentication.k8s.io/v1beta1","kind":"ExecCredential","status":{"token":"%s-%s"}}' "$FAKE_CLOUD_TOKEN" "$3"
+`
+	if err := os.WriteFile(filepath.Join(bin, "fake-cloud-auth"), []byte(helper), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("HOME", bin)
+	t.Setenv("KUBECONFIG", "")
+
+	// client-go caches credentials per exec config, each case asks for another cluster
+	tests := []struct {
+		name     string
+		command  string
+		cluster  string
+		token    string
+		wantAuth string
+		wantErr  string
+	}{
+		{name: "token from the helper", command: "fake-cloud-auth", cluster: "eks-1", token: "s3cr3t", wantAuth: "Bearer s3cr3t-eks-1"},
+		{name: "helper by absolute path", command: filepath.Join(bin, "fake-cloud-auth"), cluster: "eks-2", token: "s3cr3t", wantAuth: "Bearer s3cr3t-eks-2"},
+		{name: "helper failing", command: "fake-cloud-auth", cluster: "eks-3", wantErr: "executable fake-cloud-auth failed with exit code 1"},
+		{name: "helper not installed", command: "aws", cluster: "eks-4", wantErr: "exec plugin aws not found in PATH: install the fake helper"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("FAKE_CLOUD_TOKEN", tt.token)
+			if tt.command == "aws" {
+				t.Setenv("PATH", bin)
+			}
+			kubeconfig := writeExecKubeconfig(t, t.TempDir(), srv.URL, tt.command, tt.cluster)
+			gotAuth.Store("")
+
+			kclient, _, err := k8sClient(clientOptions{kubeconfig: kubeconfig, timeout: 5 * time.Second})
+			if err == nil {
+				_, err = kclient.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("listing namespaces: %v", err)
+			}
+			if got := gotAuth.Load(); got != tt.wantAuth {
+				t.Errorf("Authorization = %q, want %q", got, tt.wantAuth)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type listenerTLS struct {
+	certFile     string
+	keyFile      string
+	secretRef    string
+	clientCAFile string
+}
+
+func (o listenerTLS) enabled() bool {
+	return o.certFile != "" || o.keyFile != "" || o.secretRef != ""
+}
+
+// Serving certificate files, none when it comes from a secret
+func (o listenerTLS) files() []string {
+	if o.secretRef != "" {
+		return nil
+	}
+
+	return []string{o.certFile, o.keyFile}
+}
+
+func loadServingCertificate(kclient *kubernetes.Clientset, certFile, keyFile, secretRef string) (tls.Certificate, error) {
+	if secretRef == "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+
+	ns, name, ok := strings.Cut(secretRef, "/")
+	if !ok {
+		return tls.Certificate{}, fmt.Errorf("invalid secret %q, expected <namespace>/<name>", secretRef)
+	}
+
+	secret, err := kclient.CoreV1().Secrets(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error getting secret %s in namespace %s: %v", name, ns, err)
+	}
+
+	return tls.X509KeyPair(secret.Data["tls.crt"], secret.Data["tls.key"])
+}
+
+type servingCert struct {
+	load     func() (tls.Certificate, error)
+	files    []string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes map[string]time.Time
+}
+
+func newServingCert(load func() (tls.Certificate, error), files ...string) (*servingCert, error) {
+	c := &servingCert{load: load, files: files}
+	_, err := c.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Reports whether the certificate differs from the one served so far
+func (c *servingCert) reload() (bool, error) {
+	cert, err := c.load()
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	updated := c.cert == nil || !slices.EqualFunc(c.cert.Certificate, cert.Certificate, bytes.Equal)
+	c.cert = &cert
+	c.modTimes = c.stat()
+
+	return updated, nil
+}
+
+func (c *servingCert) stat() map[string]time.Time {
+	modTimes := map[string]time.Time{}
+	for _, file := range c.files {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+
+	return modTimes
+}
+
+func (c *servingCert) changed() bool {
+	// A secret has no modification time to go by, it is fetched again every time
+	if len(c.files) == 0 {
+		return true
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for file, modTime := range c.stat() {
+		if !modTime.Equal(c.modTimes[file]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *servingCert) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+func (c *servingCert) watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Reload on SIGHUP, when a cert-manager rotation rewrites the mounted files,
+	// or on every tick when the certificate comes from a secret
+	for {
+		select {
+		case <-hup:
+		case <-ticker.C:
+			if !c.changed() {
+				continue
+			}
+		}
+
+		updated, err := c.reload()
+		if err != nil {
+			fmt.Println("error reloading the serving certificate, keeping the previous one:", err)
+			continue
+		}
+		if updated {
+			fmt.Println("Reloaded the serving certificate")
+		}
+	}
+}
+
+func serverTLSConfig(cert *servingCert, clientCAFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		GetCertificate: cert.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		caData, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read client CA file: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", clientCAFile)
+		}
+
+		// Verified when presented, required per handler so /healthz stays open
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
+
+// Serves handlers over HTTPS when a serving certificate is set, plain HTTP otherwise.
+// With a client CA every handler requires a client certificate, /healthz never does.
+func listen(kclient *kubernetes.Clientset, addr string, tlsOpts listenerTLS, handlers map[string]http.Handler) error {
+	mux := http.NewServeMux()
+	for path, handler := range handlers {
+		if tlsOpts.clientCAFile != "" {
+			handler = requireClientCert(handler)
+		}
+		mux.Handle(path, handler)
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	logged, err := logRequests(os.Stderr, *accessLog, mux)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           logged,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if !tlsOpts.enabled() {
+		if tlsOpts.clientCAFile != "" {
+			return fmt.Errorf("--tls-client-ca-file requires --tls-cert-file and --tls-key-file or --tls-secret")
+		}
+		return server.ListenAndServe()
+	}
+	if tlsOpts.secretRef == "" && (tlsOpts.certFile == "" || tlsOpts.keyFile == "") {
+		return fmt.Errorf("both --tls-cert-file and --tls-key-file are required")
+	}
+
+	cert, err := newServingCert(func() (tls.Certificate, error) {
+		return loadServingCertificate(kclient, tlsOpts.certFile, tlsOpts.keyFile, tlsOpts.secretRef)
+	}, tlsOpts.files()...)
+	if err != nil {
+		return fmt.Errorf("unable to load the serving certificate: %v", err)
+	}
+	go cert.watch(30 * time.Second)
+
+	server.TLSConfig, err = serverTLSConfig(cert, tlsOpts.clientCAFile)
+	if err != nil {
+		return err
+	}
+
+	return server.ListenAndServeTLS("", "")
+}
+
+// Access log levels
+const (
+	accessLogOff    = "off"
+	accessLogErrors = "errors"
+	accessLogAll    = "all"
+)
+
+// One key=value line per request, errors only logs failed ones
+func logRequests(w io.Writer, level string, next http.Handler) (http.Handler, error) {
+	switch level {
+	case accessLogOff:
+		return next, nil
+	case accessLogErrors, accessLogAll:
+	default:
+		return nil, fmt.Errorf("invalid --access-log %q, expected off, errors or all", level)
+	}
+
+	var mu sync.Mutex
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if level == accessLogErrors && recorder.status < http.StatusBadRequest {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "time=%s method=%s path=%s status=%d duration=%s remote=%s\n", start.UTC().Format(time.RFC3339), r.Method, r.URL.Path, recorder.status, time.Since(start).Round(time.Microsecond), r.RemoteAddr)
+	}), nil
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func requireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
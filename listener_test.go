@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogRequests(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/validate", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	})
+
+	tests := []struct {
+		level   string
+		path    string
+		want    []string
+		wantErr bool
+	}{
+		{level: accessLogOff, path: "/metrics"},
+		{level: accessLogOff, path: "/validate"},
+		{level: accessLogErrors, path: "/metrics"},
+		{level: accessLogErrors, path: "/validate", want: []string{"method=GET", "path=/validate", "status=405", "duration=", "remote=192.0.2.1:1234"}},
+		{level: accessLogErrors, path: "/missing", want: []string{"path=/missing", "status=404"}},
+		{level: accessLogAll, path: "/metrics", want: []string{"method=GET", "path=/metrics", "status=200", "duration=", "remote=192.0.2.1:1234"}},
+		{level: accessLogAll, path: "/validate", want: []string{"status=405"}},
+		{level: "debug", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level+tt.path, func(t *testing.T) {
+			var log bytes.Buffer
+			handler, err := logRequests(&log, tt.level, mux)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("logRequests() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			// Logging never changes the response
+			if tt.path == "/metrics" && (rec.Code != http.StatusOK || rec.Body.String() != "ok") {
+				t.Errorf("response = %d %q, want 200 \"ok\"", rec.Code, rec.Body.String())
+			}
+			if len(tt.want) == 0 {
+				if log.Len() != 0 {
+					t.Errorf("logged %q, want nothing", log.String())
+				}
+				return
+			}
+			if strings.Count(log.String(), "\n") != 1 {
+				t.Fatalf("logged %q, want one line", log.String())
+			}
+			for _, field := range tt.want {
+				if !strings.Contains(log.String(), field) {
+					t.Errorf("logged %q, want %s", log.String(), field)
+				}
+			}
+		})
+	}
+}
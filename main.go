@@ -3,13 +3,18 @@ package main
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
+	"flag"
 	"fmt"
-	"os/exec"
+	"os"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
-	"istio.io/istio/pkg/kube"
+	"github.com/ArnauSB/check-secrets/certs"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -17,126 +22,1389 @@ import (
 	"k8s.io/client-go/kubernetes"
 )
 
+var (
+	kubeconfig           = flag.String("kubeconfig", "", "path to the kubeconfig file (overrides KUBECONFIG)")
+	kubeContext          = flag.String("context", "", "kubeconfig context to use (overrides current-context)")
+	proxyURL             = flag.String("proxy-url", "", "HTTP proxy used to reach the API server (overrides the kubeconfig proxy-url)")
+	debug                = flag.Bool("debug", false, "print debug messages to stderr")
+	requestTimeout       = flag.Duration("request-timeout", 30*time.Second, "timeout for each Kubernetes API request, including exec credential plugins")
+	checkPodRestarts     = flag.Bool("check-pod-restarts", false, "report gateway pods started before their credential secret was last modified (always on for file-mount gateways)")
+	checkEastWest        = flag.Bool("check-eastwest", false, fmt.Sprintf("check the certificates presented by east-west gateways on port %d, requires --eastwest-sni", eastWestPort))
+	eastWestSelector     = flag.String("eastwest-selector", "istio=eastwestgateway", "label selector identifying east-west gateway services")
+	eastWestSNI          = flag.String("eastwest-sni", "", "SNI sent to east-west gateways, outbound_.<port>_._.<service fqdn> of a service exposed through them")
+	showChain            = flag.Bool("show-chain", false, "print every certificate in the chain of each analyzed secret")
+	exportCerts          = flag.String("export-certs", "", "write the leaf certificate of each analyzed secret to `DIR` as PEM")
+	exportChain          = flag.Bool("export-chain", false, "export the full chain instead of the leaf with --export-certs")
+	ownerKeys            = flag.String("owner-keys", "team,owner", "comma-separated label/annotation keys holding the certificate owner, in precedence order")
+	listenAddress        = flag.String("listen-address", ":8443", "address the webhook listens on")
+	tlsCertFile          = flag.String("tls-cert-file", "", "serving certificate for the webhook and --serve")
+	tlsKeyFile           = flag.String("tls-key-file", "", "serving private key for the webhook and --serve")
+	tlsSecret            = flag.String("tls-secret", "", "`namespace/name` of a kubernetes.io/tls secret holding the webhook and --serve serving certificate")
+	tlsClientCAFile      = flag.String("tls-client-ca-file", "", "require client certificates signed by this CA bundle (except on /healthz)")
+	accessLog            = flag.String("access-log", "off", "log served requests to stderr: off, errors (status 400 and above) or all")
+	webhookMissing       = flag.String("webhook-missing-secret", ruleDeny, "webhook action when a credentialName secret is missing: deny, warn or off")
+	webhookInvalid       = flag.String("webhook-invalid-cert", ruleDeny, "webhook action when a secret holds no valid certificate, a chain not verifying or a mismatched key: deny, warn or off")
+	webhookExpired       = flag.String("webhook-expired-cert", ruleDeny, "webhook action when a certificate is already expired: deny, warn or off")
+	webhookSAN           = flag.String("webhook-san-coverage", ruleOff, "webhook action when a server host is not covered by the certificate: deny, warn or off (validate defaults to deny)")
+	webhookFailOpen      = flag.Bool("webhook-fail-open", false, "admit gateways with a warning when the webhook cannot verify them")
+	silencesFile         = flag.String("silences", "", "YAML file listing silenced secrets")
+	silencesCM           = flag.String("silences-configmap", "", "`namespace/name` of a configmap holding the silences under "+silencesKey)
+	forecastBuckets      = flag.String("forecast-buckets", "7,30,90", "comma-separated day boundaries of the expiry forecast buckets")
+	verbose              = flag.Bool("verbose", false, "list the secrets in each forecast bucket")
+	noRecheck            = flag.Bool("no-recheck", false, "report missing secrets immediately instead of checking them again at the end of the scan")
+	recheckDelay         = flag.Duration("recheck-delay", 10*time.Second, "delay before checking missing secrets again")
+	replicated           = flag.String("replicated-secrets", "", "comma-separated secret names expected to hold the same certificate everywhere they exist")
+	expectedClientCA     = flag.String("expected-client-ca", "", "PEM bundle the ca.crt of every MUTUAL server must match exactly")
+	issuer               = flag.String("issuer", "", "issuerRef for generated certificates, as `[Kind/]name`")
+	certDuration         = flag.String("duration", "2160h", "duration of generated certificates")
+	certRenewBefore      = flag.String("renew-before", "360h", "renewBefore of generated certificates")
+	dnsFromHosts         = flag.Bool("dns-from-hosts", false, "take dnsNames of generated certificates from the gateway hosts instead of the current SANs")
+	outputDir            = flag.String("output-dir", "", "write generated manifests to this directory, one file per secret")
+	allContexts          = flag.Bool("all-contexts", false, "scan every context in the kubeconfig")
+	skipContexts         = flag.String("skip-contexts", "", "glob of contexts skipped by --all-contexts")
+	clusterTimeout       = flag.Duration("cluster-timeout", 5*time.Minute, "maximum time spent scanning one cluster with --all-contexts")
+	hubSecretSelector    = flag.String("hub-secret-selector", "", "label selector of secrets in this (hub) cluster holding spoke cluster kubeconfigs to scan")
+	hubSecretKey         = flag.String("hub-secret-key", "value", "key of the kubeconfig in hub secrets")
+	cacheDir             = flag.String("cache-dir", defaultCacheDir(), "directory caching the namespace list between runs")
+	cacheTTL             = flag.Duration("cache-ttl", 2*time.Minute, "how long cached API responses are reused")
+	noCache              = flag.Bool("no-cache", false, "always query the API server instead of the on-disk cache")
+	dedupeBySecret       = flag.Bool("dedupe-by-secret", false, "report each secret once with every gateway server referencing it")
+	top                  = flag.Int("top", 0, "only show the N soonest expiring certificates (ties included)")
+	wide                 = flag.Bool("wide", false, "include extra details, such as the secret manager, in each certificate line")
+	managedBy            = flag.String("managed-by", "", "only report secrets with this manager (cert-manager, external-secrets, istio, sealed-secrets, manual or a --manager-rule name)")
+	checkMeshCA          = flag.Bool("check-mesh-ca", false, "check the caCertificates trust anchors configured in meshConfig")
+	meshConfigRef        = flag.String("mesh-config", "istio-system/istio", "`NAMESPACE/NAME` of the ConfigMap holding meshConfig")
+	staleInstallFraction = flag.Float64("stale-install-fraction", 0.5, "warn when a secret was written with less than this fraction of its certificate lifetime left (0 disables)")
+	showTimings          = flag.Bool("show-timings", false, "print time spent per scan step and the slowest namespaces to stderr")
+	maxAPIRequests       = flag.Int64("max-api-requests", 0, "stop starting new namespaces when this API request budget is nearly used (0 disables)")
+	maxSecretSize        = flag.Int("max-secret-size", 16384, "flag gateway secrets whose data exceeds this many bytes (0 disables)")
+	extraSecretKeys      = flag.String("extra-secret-keys", "", "comma separated secret keys to accept besides the ones Istio reads")
+	warnDays             = flag.Int("warn-days", 0, "exit with code 2 when a certificate expires within this many days (0 disables)")
+	critDays             = flag.Int("crit-days", 0, "exit with code 3 when a certificate expires within this many days (0 disables)")
+	clusterConcurrency   = flag.Int("cluster-concurrency", 1, "number of clusters scanned at the same time with --all-contexts or --hub-secret-selector")
+	requireAllClusters   = flag.Bool("require-all-clusters", false, "exit with code 3 when any cluster fails or times out instead of reporting it as partial")
+	sources              = flag.String("sources", "istio,gateway-api,ingress", "comma separated objects whose TLS secrets are scanned: istio, gateway-api, ingress")
+	concurrency          = flag.Int("concurrency", 5, "number of namespaces scanned at the same time in each cluster")
+	namespaceSelector    = flag.String("namespace-selector", "", "label selector restricting the scanned namespaces")
+	gatewaySelector      = flag.String("gateway-selector", "", "label selector restricting the scanned Istio gateways, Gateway API gateways and Ingresses")
+	checkRevisions       = flag.Bool("check-revisions", false, "resolve the Istio revisions serving each gateway and check its secrets exist for all of them")
+	revision             = flag.String("revision", "", "only scan gateways served by this Istio revision (implies --check-revisions)")
+	sampleWorkloads      = flag.Int("sample-workload-certs", 0, "read the workload certificate of up to N sidecar pods per namespace through pods/proxy (0 disables)")
+	envoyAdminPort       = flag.String("envoy-admin-port", "15000", "pod port serving the Envoy admin /certs endpoint, which must listen on the pod IP (Istio binds it to localhost)")
+	workloadStaleRatio   = flag.Float64("workload-stale-fraction", 0.25, "count sampled workload certificates with less than this fraction of their lifetime left as stale (0 disables)")
+	dryRun               = flag.Bool("dry-run", false, "print what --export-certs, --archive, generate, baseline update and the notifiers would write or send instead of doing it")
+	baselineFile         = flag.String("baseline", "", "JSON report whose findings are known and left out of the exit code, or written by baseline update")
+	asOf                 = flag.String("as-of", "", "evaluate expiry as of this date (YYYY-MM-DD or RFC 3339) instead of now")
+	printAccessSummary   = flag.Bool("print-access-summary", false, "print every API verb and resource used by the scan, and a role granting exactly those")
+	archiveFile          = flag.String("archive", "", "write the JSON report with the certificates and gateway specs backing each finding to this .tar.gz `FILE`")
+	serve                = flag.Bool("serve", false, "keep running, rescanning every --scan-interval and serving Prometheus metrics on --metrics-address")
+	scanInterval         = flag.Duration("scan-interval", 5*time.Minute, "time between scans with --serve")
+	metricsAddress       = flag.String("metrics-address", ":9090", "address --serve exposes /metrics and /healthz on")
+	pageSize             = flag.Int64("page-size", 500, "items per page of large list requests (0 lists everything at once)")
+	notifyWebhook        = flag.String("notify-webhook", "", "URL findings needing attention are POSTed to as JSON after each scan")
+	notifySlack          = flag.String("notify-slack", "", "Slack incoming webhook URL findings needing attention are sent to after each scan")
+	notifyOnChange       = flag.Bool("notify-on-change", false, "only notify when the findings needing attention changed since the last notification")
+	notifyStateFile      = flag.String("notify-state", "", "file remembering the last notified findings for --notify-on-change")
+	dialTimeout          = flag.Duration("dial-timeout", 5*time.Second, "timeout for live TLS connections")
+)
+
+var (
+	output        string
+	allNamespaces bool
+)
+
+func init() {
+	flag.StringVar(&output, "output", "text", "output format: text, json, yaml or table for scans, text or csv for inventory")
+	flag.StringVar(&output, "o", "text", "shorthand for --output")
+	flag.Var(&namespaces, "namespace", "namespaces to scan instead of every namespace (comma separated, repeatable)")
+	flag.Var(&namespaces, "n", "shorthand for --namespace")
+	flag.BoolVar(&allNamespaces, "all-namespaces", false, "scan every namespace, including the --exclude-namespace ones")
+	flag.BoolVar(&allNamespaces, "A", false, "shorthand for --all-namespaces")
+	flag.Var(&excludeNamespaces, "exclude-namespace", "namespaces never scanned (comma separated, repeatable, replaces the default)")
+	flag.Var(&excludeNamespaces, "exclude-namespaces", "alias of --exclude-namespace")
+	flag.Var(&manifests, "f", "manifest `file or directory` checked by the validate command (repeatable)")
+	flag.Var(&kubeContexts, "contexts", "kubeconfig contexts to scan, like --all-contexts for only these (comma separated, repeatable)")
+	flag.Var(&findHosts, "host", "host the certificate must cover for find-cert (comma separated, repeatable)")
+	flag.Var(&customManagers, "manager-rule", "`NAME=MATCH` rule attributing secrets to an in-house manager by label/annotation prefix, owner kind or field manager (repeatable)")
+}
+
+var (
+	customManagers    managerRules
+	manifests         manifestFiles
+	namespaces        listFlag
+	findHosts         listFlag
+	kubeContexts      listFlag
+	excludeNamespaces = listFlag{values: []string{"kube-system", "xcp-multicluster"}}
+)
+
+// Comma separated and repeatable, the first use replaces the default
+type listFlag struct {
+	values []string
+	set    bool
+}
+
+func (f *listFlag) String() string {
+	return strings.Join(f.values, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	if !f.set {
+		f.values, f.set = nil, true
+	}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			f.values = append(f.values, v)
+		}
+	}
+
+	return nil
+}
+
+// Same layout openssl uses for notAfter, so every report line reads alike
+const opensslTimeFormat = "Jan _2 15:04:05 2006 MST"
+
+var gatewayResource = schema.GroupVersionResource{
+	Group:    "networking.istio.io",
+	Version:  "v1alpha3",
+	Resource: "gateways",
+}
+
 func main() {
+	// Split an optional subcommand from the flags
+	cmd, args := "", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	apiBudget.limit = *maxAPIRequests
+
+	if len(kubeContexts.values) > 0 && (*allContexts || *kubeContext != "") {
+		fmt.Println("--contexts can't be combined with --all-contexts or --context")
+		return
+	}
+
+	if allNamespaces && len(namespaces.values) > 0 {
+		fmt.Println("--all-namespaces and --namespace can't be combined")
+		return
+	}
+
+	// AUTO_PASSTHROUGH only completes handshakes for services it routes
+	if *checkEastWest && *eastWestSNI == "" {
+		fmt.Println("--check-eastwest requires --eastwest-sni, e.g. outbound_.8080_._.httpbin.sample.svc.cluster.local")
+		return
+	}
+
+	if *asOf != "" {
+		t, err := parseAsOf(*asOf)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		clock = func() time.Time { return t }
+		fmt.Fprintf(os.Stderr, "Evaluating expiry as of %s\n", t.UTC().Format(time.RFC3339))
+	}
+
+	if cmd != "" && cmd != "explain" && cmd != "inventory" && cmd != "webhook" && cmd != "silences" && cmd != "forecast" && cmd != "generate" && cmd != "validate" && cmd != "baseline" && cmd != "find-cert" && cmd != "self-test" {
+		fmt.Printf("unknown command %q\n", cmd)
+		return
+	}
+
+	// Needs no cluster, all it scans is served from memory
+	if cmd == "self-test" {
+		os.Exit(selfTest())
+	}
+
+	// baseline update is a regular scan, saved instead of compared
+	updateBaseline := false
+	if cmd == "baseline" {
+		if len(flag.Args()) != 1 || flag.Arg(0) != "update" || *baselineFile == "" {
+			fmt.Println("usage: check-secrets baseline update --baseline FILE")
+			return
+		}
+		cmd, updateBaseline = "", true
+	}
+
+	// Serve mode keeps scanning, only metrics come out of it
+	if *serve && (cmd != "" || updateBaseline || *exportCerts != "" || *archiveFile != "") {
+		fmt.Println("--serve only works for scans, without --export-certs or --archive")
+		return
+	}
+
+	// Keep stdout for the machine-readable scan report, everything else goes to stderr
+	stdout := os.Stdout
+	if cmd == "" {
+		switch output {
+		case "text":
+		case "json", "yaml", "table":
+			os.Stdout = os.Stderr
+		default:
+			fmt.Printf("unsupported output format %q, expected text, json, yaml or table\n", output)
+			return
+		}
+	}
+
 	// Get k8s clients
-	kclient, dclient, err := k8sClient()
+	kclient, dclient, err := k8sClient(clientOptions{
+		kubeconfig: *kubeconfig,
+		context:    *kubeContext,
+		timeout:    *requestTimeout,
+		proxyURL:   *proxyURL,
+	})
 	if err != nil {
 		fmt.Println("error creating the k8s clients:", err)
 		return
 	}
 
-	// Get namespaces list
-	nsList, err := getNamespaces(kclient)
+	// Load the silences, reporting the expired ones
+	silences, err := loadSilences(kclient, *silencesFile, *silencesCM)
 	if err != nil {
-		fmt.Println("error getting the list of namespaces:", err)
+		fmt.Println("error loading silences:", err)
 		return
 	}
 
-	// Get resources per namespace
-	err = getNsGateways(kclient, dclient, nsList)
+	if cmd == "silences" {
+		if len(flag.Args()) != 1 || flag.Arg(0) != "list" {
+			fmt.Println("usage: check-secrets silences list [--silences FILE | --silences-configmap NS/NAME]")
+			return
+		}
+		err = listSilences(silences)
+		if err != nil {
+			fmt.Println("error listing silences:", err)
+		}
+		return
+	}
+
+	if cmd == "explain" {
+		err = explain(kclient, dclient, flag.Args())
+		if err != nil {
+			fmt.Println("error explaining object:", err)
+		}
+		return
+	}
+
+	if cmd == "webhook" {
+		err = serveWebhook(kclient, *listenAddress, listenerTLS{
+			certFile:     *tlsCertFile,
+			keyFile:      *tlsKeyFile,
+			secretRef:    *tlsSecret,
+			clientCAFile: *tlsClientCAFile,
+		}, webhookRules{
+			missingSecret: *webhookMissing,
+			invalidCert:   *webhookInvalid,
+			expiredCert:   *webhookExpired,
+			sanCoverage:   *webhookSAN,
+			failOpen:      *webhookFailOpen,
+
+			warnDays:             *warnDays,
+			staleInstallFraction: *staleInstallFraction,
+		})
+		if err != nil {
+			fmt.Println("error serving the admission webhook:", err)
+		}
+		return
+	}
+
+	if cmd == "validate" {
+		namespace, _, err := clientConfig(*kubeconfig, *kubeContext).Namespace()
+		if err != nil {
+			fmt.Println("error getting the default namespace:", err)
+			os.Exit(1)
+		}
+		// Manifests are checked before they ship, so uncovered hosts deny unless asked otherwise
+		sanCoverage := ruleDeny
+		if flagSet("webhook-san-coverage") {
+			sanCoverage = *webhookSAN
+		}
+		passed, err := validateManifests(kclient, manifests, namespace, webhookRules{
+			missingSecret: *webhookMissing,
+			invalidCert:   *webhookInvalid,
+			expiredCert:   *webhookExpired,
+			sanCoverage:   sanCoverage,
+
+			warnDays:             *warnDays,
+			staleInstallFraction: *staleInstallFraction,
+		})
+		if err != nil {
+			fmt.Println("error validating manifests:", err)
+			os.Exit(1)
+		}
+		if !passed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Get namespaces list, per cluster when scanning every context
+	var nsList []string
+	if cmd != "" {
+		nsList, err = getNamespaces(kclient)
+		if err != nil {
+			fmt.Println("error getting the list of namespaces:", err)
+			return
+		}
+	}
+
+	if cmd == "inventory" {
+		err = inventory(kclient, dclient, nsList, output, strings.Split(*ownerKeys, ","))
+		if err != nil {
+			fmt.Println("error building the inventory:", err)
+		}
+		return
+	}
+
+	if cmd == "find-cert" {
+		err = findCertificates(kclient, dclient, nsList, findHosts.values)
+		if err != nil {
+			fmt.Println("error finding certificates:", err)
+		}
+		return
+	}
+
+	if cmd == "forecast" {
+		err = forecast(kclient, dclient, nsList, *forecastBuckets, *verbose)
+		if err != nil {
+			fmt.Println("error building the forecast:", err)
+		}
+		return
+	}
+
+	if cmd == "generate" {
+		err = generateCertificates(kclient, dclient, nsList, flag.Args(), certificateOptions{
+			issuer:      *issuer,
+			duration:    *certDuration,
+			renewBefore: *certRenewBefore,
+			fromHosts:   *dnsFromHosts,
+			outputDir:   *outputDir,
+			dryRun:      *dryRun,
+		})
+		if err != nil {
+			fmt.Println("error generating certificates:", err)
+		}
+		return
+	}
+
+	// Prepare the certificate export directory
+	var exporter *certExporter
+	if *exportCerts != "" {
+		exporter, err = newCertExporter(*exportCerts, *exportChain, *dryRun)
+		if err != nil {
+			fmt.Println("error preparing certificate export:", err)
+			return
+		}
+	}
+
+	// Evidence for auditors, written while the scan runs
+	var archive *reportArchive
+	if *archiveFile != "" {
+		archive, err = openReportArchive(*archiveFile, *dryRun)
+		if err != nil {
+			fmt.Println("error preparing the archive:", err)
+			return
+		}
+	}
+
+	// Load the organizational client CA bundle
+	var clientCAs map[string]string
+	if *expectedClientCA != "" {
+		clientCAs, err = loadCABundle(*expectedClientCA)
+		if err != nil {
+			fmt.Println("error loading the expected client CA bundle:", err)
+			return
+		}
+	}
+
+	// Findings already known are reported but don't fail the run
+	var baseline map[string]bool
+	if *baselineFile != "" && !updateBaseline {
+		baseline, err = loadBaseline(*baselineFile)
+		if err != nil {
+			fmt.Println("error loading the baseline:", err)
+			return
+		}
+	}
+
+	// Findings are pushed to these once the scan is done
+	var notifiers []notifier
+	if *notifyWebhook != "" {
+		notifiers = append(notifiers, webhookNotifier{url: *notifyWebhook})
+	}
+	if *notifySlack != "" {
+		notifiers = append(notifiers, slackNotifier{url: *notifySlack})
+	}
+	if *notifyOnChange && *notifyStateFile == "" && !*serve {
+		fmt.Println("--notify-on-change needs --notify-state to remember the last notification, except with --serve")
+		return
+	}
+	notifications, err := loadNotifyState(*notifyStateFile)
 	if err != nil {
-		fmt.Println("error getting resources per namespace:", err)
+		fmt.Println(err)
 		return
 	}
-}
 
-func k8sClient() (*kubernetes.Clientset, dynamic.Interface, error) {
-	clientcfg := kube.BuildClientCmd("", "")
-	restConfig, err := clientcfg.ClientConfig()
+	scanSources, err := parseSources(*sources)
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to get k8s config file: %v", err)
+		fmt.Println("error parsing --sources:", err)
+		return
+	}
+
+	// Every scan starts from the same settings, serve mode runs many
+	newScan := func() *scanContext {
+		scan := &scanContext{
+			exporter:  exporter,
+			archive:   archive,
+			silences:  silences,
+			baseline:  baseline,
+			drift:     newDriftTracker(strings.Split(*replicated, ",")),
+			clientCAs: clientCAs,
+			sources:   scanSources,
+		}
+		if *showTimings {
+			scan.timings = newScanTimings()
+		}
+		return scan
+	}
+	runScan := func(scan *scanContext) error {
+		switch {
+		case *allContexts || len(kubeContexts.values) > 0:
+			targets, err := contextTargets(*kubeconfig, *skipContexts, kubeContexts.values)
+			if err != nil {
+				return fmt.Errorf("error listing kubeconfig contexts: %v", err)
+			}
+			scanClusters(targets, scan, *clusterConcurrency, *clusterTimeout)
+		case *hubSecretSelector != "":
+			targets, err := hubTargets(kclient, *hubSecretSelector, *hubSecretKey)
+			if err != nil {
+				return fmt.Errorf("error listing spoke cluster kubeconfigs: %v", err)
+			}
+			scanClusters(targets, scan, *clusterConcurrency, *clusterTimeout)
+		default:
+			// Listed on every scan, so --serve picks up namespaces created since start
+			nsList, err := getNamespaces(kclient)
+			if err != nil {
+				return fmt.Errorf("error getting the list of namespaces: %v", err)
+			}
+			scan.cluster = currentContext(*kubeconfig, *kubeContext)
+			return scanCluster(kclient, dclient, nsList, scan)
+		}
+		return nil
 	}
 
-	k8sClient, err := kubernetes.NewForConfig(restConfig)
+	if *serve {
+		err = serveMetrics(kclient, *metricsAddress, listenerTLS{
+			certFile:     *tlsCertFile,
+			keyFile:      *tlsKeyFile,
+			secretRef:    *tlsSecret,
+			clientCAFile: *tlsClientCAFile,
+		}, *scanInterval, func() (*scanContext, error) {
+			// --max-api-requests is a budget per scan
+			apiBudget.used.Store(0)
+			scan := newScan()
+			scan.buffered = true
+			err := runScan(scan)
+			if err == nil && len(notifiers) > 0 {
+				notify(notifiers, notifications, *notifyOnChange, *dryRun, scan.results)
+			}
+			return scan, err
+		})
+		if err != nil {
+			fmt.Println("error serving metrics:", err)
+		}
+		return
+	}
+
+	scan := newScan()
+	err = runScan(scan)
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to create k8s client: %v", err)
+		fmt.Println(err)
+		return
+	}
+
+	results := scan.results
+	var shown, total int
+	if *top > 0 {
+		results, total = selectTop(results, *top)
+
+		for _, r := range results {
+			if r.NotAfter != nil {
+				shown++
+				if output == "text" {
+					fmt.Println(r.text)
+				}
+			}
+		}
+		fmt.Printf("Showing the %d soonest expiring of %d certificates\n", shown, total)
+	}
+
+	printManagerCounts(scan.managers)
+	restricted := 0
+	for _, r := range scan.results {
+		if r.Status == statusUnknown {
+			restricted++
+		}
+	}
+	if restricted > 0 {
+		fmt.Printf("%d secrets with content not accessible, expiry unknown\n", restricted)
+	}
+	scan.reportErrors()
+	scan.drift.report()
+	scan.timings.report()
+	if apiBudget.limit > 0 {
+		fmt.Fprintf(os.Stderr, "API requests: %d of %d\n", apiBudget.used.Load(), apiBudget.limit)
+	}
+	if *printAccessSummary {
+		err = apiAccess.report(os.Stdout)
+		if err != nil {
+			fmt.Println("error printing the access summary:", err)
+		}
+	}
+
+	if exporter != nil {
+		err = exporter.writeManifest()
+		if err != nil {
+			fmt.Println("error exporting certificates:", err)
+			return
+		}
+	}
+
+	rep := newReport(scan, results)
+	if *top > 0 {
+		rep.Truncated, rep.TotalDated = shown < total, total
+	}
+
+	if archive != nil {
+		err = archive.close(rep)
+		if err != nil {
+			fmt.Println("error writing the archive:", err)
+			return
+		}
 	}
 
-	k8sDynClient, err := dynamic.NewForConfig(restConfig)
+	switch output {
+	case "json":
+		err = renderJSON(stdout, rep)
+	case "yaml":
+		err = renderYAML(stdout, rep)
+	case "table":
+		err = renderTable(stdout, rep)
+	}
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to create k8s dynamic client: %v", err)
+		fmt.Println("error writing the report:", err)
+		return
+	}
+
+	if len(notifiers) > 0 {
+		notify(notifiers, notifications, *notifyOnChange, *dryRun, scan.results)
+	}
+
+	if updateBaseline {
+		if *dryRun {
+			fmt.Fprintf(os.Stderr, "dry-run: would write baseline %s with %d findings\n", *baselineFile, len(scan.results))
+			return
+		}
+		err = writeBaseline(*baselineFile, newReport(scan, scan.results))
+		if err != nil {
+			fmt.Println("error updating the baseline:", err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Baseline %s updated with %d findings\n", *baselineFile, len(scan.results))
+		return
 	}
 
-	return k8sClient, k8sDynClient, nil
+	// Every finding fails the run, not only those kept by --top
+	code := exitCode(scan.results)
+	for _, status := range scan.clusters {
+		if *requireAllClusters && status.Status != clusterScanned {
+			code = exitCritical
+		}
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
 }
 
-func getNamespaces(kclient *kubernetes.Clientset) ([]string, error) {
-	var nsNames []string
-	nsList, err := kclient.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+func scanCluster(kclient *kubernetes.Clientset, dclient dynamic.Interface, nsList []string, scan *scanContext) error {
+	// Get resources per namespace
+	err := getNsGateways(kclient, dclient, nsList, scan)
+	if err != nil {
+		return fmt.Errorf("error getting resources per namespace: %v", err)
+	}
+
+	// Check mesh-internal certificates
+	if *checkEastWest {
+		start := time.Now()
+		err = scan.checkEastWestGateways(kclient, *eastWestSelector, *eastWestSNI, *dialTimeout)
+		scan.timings.step("east-west live checks", start)
+		if err != nil {
+			return fmt.Errorf("error checking east-west gateways: %v", err)
+		}
+	}
+
+	// Check extra trust anchors from meshConfig
+	if *checkMeshCA {
+		start := time.Now()
+		err = checkMeshCACertificates(kclient, *meshConfigRef)
+		scan.timings.step("mesh trust anchors", start)
+		if err != nil {
+			return fmt.Errorf("error checking mesh trust anchors: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Results of several clusters are reported together
+func multiCluster() bool {
+	return *allContexts || len(kubeContexts.values) > 0 || *hubSecretSelector != ""
+}
+
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+
+	return set
+}
+
+func debugf(format string, args ...interface{}) {
+	if *debug {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+func listNamespaceNames(kclient *kubernetes.Clientset) ([]string, error) {
+	// Cache entries are keyed by API server so clusters don't collide
+	server := kclient.CoreV1().RESTClient().Get().URL().Host
+	if *namespaceSelector != "" {
+		server += "?labelSelector=" + *namespaceSelector
+	}
+	if !*noCache && *cacheDir != "" {
+		if names, ok := readNamespaceCache(*cacheDir, server, *cacheTTL); ok {
+			return names, nil
+		}
+	}
+
+	nsList, err := listNamespaces(kclient, metav1.ListOptions{LabelSelector: *namespaceSelector})
 	if err != nil {
-		return nil, fmt.Errorf("unable to get the list of namespaces: %v", err)
+		return nil, fmt.Errorf("unable to get the list of namespaces: %w", err)
 	}
 
+	var names []string
 	for _, ns := range nsList.Items {
-		if ns.Name != "kube-system" && ns.Name != "xcp-multicluster" {
-			nsNames = append(nsNames, ns.Name)
+		names = append(names, ns.Name)
+	}
+
+	if !*noCache && *cacheDir != "" {
+		writeNamespaceCache(*cacheDir, server, names)
+	}
+
+	return names, nil
+}
+
+func getNamespaces(kclient *kubernetes.Clientset) ([]string, error) {
+	// Explicit namespaces need no list permission
+	names := namespaces.values
+	if len(names) == 0 {
+		var err error
+		names, err = listNamespaceNames(kclient)
+		if err != nil {
+			return nil, err
 		}
 	}
 
+	excluded := map[string]bool{}
+	for _, name := range excludeNamespaces.values {
+		excluded[name] = !allNamespaces
+	}
+
+	var nsNames []string
+	for _, name := range names {
+		if !excluded[name] {
+			nsNames = append(nsNames, name)
+		}
+	}
+	sort.Strings(nsNames)
+
 	return nsNames, nil
 }
 
-func getNsGateways(kclient *kubernetes.Clientset, dclient dynamic.Interface, nsList []string) error {
+type scanContext struct {
+	cluster  string
+	exporter *certExporter
+	archive  *reportArchive
+	silences []silence
+	drift    *driftTracker
+
+	// Finding IDs from --baseline, nil when not set
+	baseline map[string]bool
+
+	// Expected client CA fingerprints, nil when not checked
+	clientCAs map[string]string
+
+	// Everything reported so far, rendered by main
+	results []result
+
+	// Reported certificates per secret manager
+	managers map[string]int
+
+	// Nil unless --show-timings is set
+	timings *scanTimings
+
+	errors []scanError
+
+	// Lines are printed by whoever merges this cluster's results
+	buffered bool
+	output   bytes.Buffer
+
+	// Per-cluster outcome of a multi-cluster scan
+	clusters []clusterStatus
+
+	// Enabled --sources
+	sources map[string]bool
+
+	// Sources skipped because their CRDs are not installed
+	disabled []disabledSource
+
+	// Per-namespace summaries of --sample-workload-certs
+	workloads []workloadSummary
+}
+
+func getNsGateways(kclient *kubernetes.Clientset, dclient dynamic.Interface, nsList []string, scan *scanContext) error {
 	var (
-		gwNum int
+		skipped []string
+		costMu  sync.Mutex
+		nsCost  int64 = 1
+		wg      sync.WaitGroup
 	)
 
-	gwRes := schema.GroupVersionResource{
-		Group:    "networking.istio.io",
-		Version:  "v1alpha3",
-		Resource: "gateways",
+	// Missing CRDs are remembered between runs like the namespace list
+	server := kclient.CoreV1().RESTClient().Get().URL().Host
+	var cached []string
+	if !*noCache && *cacheDir != "" {
+		cached = readGroupCache(*cacheDir, server, *cacheTTL)
+	}
+	absent := newAbsentGroups(scan.cluster, cached)
+
+	workers := *concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	children := make([]*scanContext, len(nsList))
+	rechecks := make([][]recheck, len(nsList))
+
+	for i, ns := range nsList {
+		// Leave enough budget to finish a namespace once started
+		costMu.Lock()
+		cost := nsCost
+		costMu.Unlock()
+		if apiBudget.limit > 0 && apiBudget.remaining() < cost {
+			skipped = nsList[i:]
+			break
+		}
+
+		// Each namespace collects on its own, merged back in namespace order
+		i, ns := i, ns
+		child := scan.fork(scan.cluster)
+		child.buffered = true
+		children[i] = child
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			used := apiBudget.used.Load()
+			nsStart := time.Now()
+			rechecks[i] = child.scanNamespace(kclient, dclient, ns, absent)
+
+			nsName := ns
+			if multiCluster() {
+				nsName = scan.cluster + "/" + ns
+			}
+			scan.timings.namespace(nsName, nsStart)
+
+			// Concurrent namespaces share the counter, so this overestimates
+			costMu.Lock()
+			if cost := apiBudget.used.Load() - used; cost > nsCost {
+				nsCost = cost
+			}
+			costMu.Unlock()
+		}()
 	}
+	wg.Wait()
 
-	for _, ns := range nsList {
+	var (
+		all           []recheck
+		uniqueSecrets int
+		references    int
+	)
+	for i, child := range children {
+		if child == nil {
+			continue
+		}
+		sortResults(child.results)
+		for _, r := range child.results {
+			if r.References != nil {
+				uniqueSecrets++
+				references += len(r.References)
+			}
+		}
+		scan.merge(child)
+		all = append(all, rechecks[i]...)
+	}
+
+	if len(skipped) > 0 {
+		scan.recordError("namespaces", "", fmt.Errorf("%w at %d of %d requests, %d namespaces not scanned: %s", errBudgetExhausted, apiBudget.used.Load(), apiBudget.limit, len(skipped), strings.Join(skipped, ", ")), true)
+	}
+
+	scan.disabled = append(scan.disabled, absent.list()...)
+	if groups := absent.detected(); len(groups) > 0 && !*noCache && *cacheDir != "" {
+		writeGroupCache(*cacheDir, server, groups)
+	}
+
+	if *dedupeBySecret {
+		fmt.Fprintf(scan.out(), "Summary: %d unique secrets referenced by %d gateway servers\n", uniqueSecrets, references)
+	}
+
+	scan.recheckMissingSecrets(kclient, all, *recheckDelay)
+
+	return nil
+}
+
+func (scan *scanContext) scanNamespace(kclient *kubernetes.Clientset, dclient dynamic.Interface, ns string, absent *absentGroups) []recheck {
+	var rechecks []recheck
+
+	// Every certificate use in the namespace, reported once all sources are collected
+	var uses []secretUse
+	lookup := newSecretLookup(kclient)
+
+	// Missing secrets may be mid-rotation, check them again at the end
+	addMissing := func(missing []missingSecret) {
+		for _, m := range missing {
+			r := result{Namespace: ns, ReferencedBy: []referrer{m.by}, Ports: m.ports, Hosts: m.hosts, Secret: m.name, Revisions: m.revisions, clientCA: m.clientCA}
+			if m.namespace != ns {
+				r.SecretNamespace = m.namespace
+			}
+			if *noRecheck {
+				r.setError(findingSecretMissing, fmt.Errorf("secret not found"), fmt.Sprintf("error getting secret %s for %s in namespace %s: secret not found", m.name, m.by, ns))
+				scan.emit(r)
+				continue
+			}
+			rechecks = append(rechecks, recheck{res: r, secretNamespace: m.namespace})
+		}
+	}
+	addError := func(by referrer, resource string, err error) {
+		r := result{Namespace: ns, ReferencedBy: []referrer{by}}
+		r.setError(findingSecretUnreadable, err, fmt.Sprintf("error getting secrets for %s in namespace %s: %v", by, ns, err))
+		scan.emit(r)
+		scan.recordError(resource, ns, err, true)
+	}
+
+	if scan.sources[sourceIstio] && !absent.isDisabled(sourceIstio) {
 		// Get gateways per namespace
-		gwList, err := dclient.Resource(gwRes).Namespace(ns).List(context.TODO(), metav1.ListOptions{})
+		start := time.Now()
+		gwList, err := listObjects(dclient.Resource(gatewayResource).Namespace(ns), metav1.ListOptions{LabelSelector: *gatewaySelector})
+		scan.timings.step("gateway list", start)
+		if apierrors.IsNotFound(err) {
+			// Istio CRDs not installed in this cluster
+			absent.disable(sourceIstio, "")
+			gwList, err = &unstructured.UnstructuredList{}, nil
+		}
 		if err != nil {
-			return err
+			// Reported for this namespace only, the others are still scanned
+			fmt.Fprintf(scan.out(), "error listing gateways in namespace %s: %v\n", ns, err)
+			scan.recordError("gateways", ns, err, true)
+			gwList = &unstructured.UnstructuredList{}
 		}
-		gwNum = len(gwList.Items)
 
-		if gwNum > 0 {
-			// Iterate over each gateway
+		// Iterate over each gateway
+		for _, gw := range gwList.Items {
+			by := referrer{Kind: kindIstioGateway, Name: gw.GetName()}
+
+			// Resolve the revisions serving the gateway during canary upgrades
+			var revisions []string
+			if *checkRevisions || *revision != "" {
+				byRevision, err := gatewayRevisions(kclient, gw)
+				if err != nil {
+					fmt.Fprintf(scan.out(), "error resolving revisions for gateway %s in namespace %s: %v\n", gw.GetName(), ns, err)
+				} else {
+					checkRevisionSecrets(scan.out(), lookup, gw, byRevision, *revision)
+					revisions = sortedRevisions(byRevision)
+				}
+				if *revision != "" && len(byRevision[*revision]) == 0 {
+					debugf("Skipping gateway %s in namespace %s, not served by revision %s", gw.GetName(), ns, *revision)
+					continue
+				}
+			}
+
+			// Spec checks need no secret access
+			checkGatewaySpec(scan.out(), gw)
+			if scan.archive != nil {
+				scan.archive.addObject(scan.cluster, ns, by, gw.Object)
+			}
+
+			// Get secrets per gateway
+			start := time.Now()
+			secrets, missing, err := getGatewaySecrets(lookup, gw)
+			scan.timings.step("secret gets", start)
+			if err != nil {
+				addError(by, "gateway "+gw.GetName()+" secrets", err)
+				continue
+			}
+			for _, name := range missing {
+				addMissing([]missingSecret{{
+					namespace: ns,
+					name:      name,
+					by:        by,
+					ports:     serverPortsForSecret(gw, name),
+					hosts:     gatewayHostsForSecret([]unstructured.Unstructured{gw}, name),
+					revisions: revisions,
+					clientCA:  mutualServerForSecret(gw, name),
+				}})
+			}
+
+			for _, use := range istioGatewayUses(gw, secrets) {
+				use.revisions = revisions
+				uses = append(uses, use)
+			}
+
+			if scan.clientCAs != nil {
+				checkGatewayClientCA(scan.out(), kclient, gw, secrets, scan.clientCAs)
+			}
+
+			// Check for pods still serving the certificate loaded before the last rotation
+			if *checkPodRestarts || isFileMountGateway(gw) {
+				start := time.Now()
+				err = checkGatewayPodRestarts(scan.out(), kclient, gw, secrets)
+				scan.timings.step("pod restart checks", start)
+				if err != nil {
+					fmt.Fprintf(scan.out(), "error checking pod restarts for gateway %s in namespace %s: %v\n", gw.GetName(), ns, err)
+					scan.recordError("gateway "+gw.GetName()+" pods", ns, err, false)
+				}
+			}
+		}
+	}
+
+	if scan.sources[sourceGatewayAPI] && !absent.isDisabled(sourceGatewayAPI) {
+		start := time.Now()
+		gwList, err := listObjects(dclient.Resource(kubeGatewayResource).Namespace(ns), metav1.ListOptions{LabelSelector: *gatewaySelector})
+		scan.timings.step("gateway list", start)
+		switch {
+		case apierrors.IsNotFound(err):
+			// Gateway API CRDs not installed in this cluster
+			absent.disable(sourceGatewayAPI, "")
+		case err != nil:
+			fmt.Fprintf(scan.out(), "error listing Gateway API gateways in namespace %s: %v\n", ns, err)
+			scan.recordError("Gateway API gateways", ns, err, true)
+		default:
 			for _, gw := range gwList.Items {
-				// Get secrets per gateway
-				secrets, err := getGatewaySecrets(kclient, gw)
+				if scan.archive != nil {
+					scan.archive.addObject(scan.cluster, ns, referrer{Kind: kindGateway, Name: gw.GetName()}, gw.Object)
+				}
+				start := time.Now()
+				found, missing, err := kubeGatewaySecrets(scan.out(), lookup, dclient, gw)
+				scan.timings.step("secret gets", start)
+				if err != nil {
+					addError(referrer{Kind: kindGateway, Name: gw.GetName()}, "Gateway API gateway "+gw.GetName()+" secrets", err)
+					continue
+				}
+				addMissing(missing)
+				uses = append(uses, found...)
+			}
+		}
+	}
+
+	if scan.sources[sourceIngress] {
+		start := time.Now()
+		ingList, err := listIngresses(kclient, ns, metav1.ListOptions{LabelSelector: *gatewaySelector})
+		scan.timings.step("ingress list", start)
+		if err != nil {
+			fmt.Fprintf(scan.out(), "error listing ingresses in namespace %s: %v\n", ns, err)
+			scan.recordError("ingresses", ns, err, true)
+		} else {
+			for _, ing := range ingList.Items {
+				if scan.archive != nil {
+					ing.APIVersion, ing.Kind = "networking.k8s.io/v1", "Ingress"
+					scan.archive.addObject(scan.cluster, ns, referrer{Kind: kindIngress, Name: ing.Name}, ing)
+				}
+				start := time.Now()
+				found, missing, err := ingressSecrets(lookup, ing)
+				scan.timings.step("secret gets", start)
 				if err != nil {
-					fmt.Printf("error getting secrets for gateway in namespace %s: %v\n", ns, err)
+					addError(referrer{Kind: kindIngress, Name: ing.Name}, "ingress "+ing.Name+" secrets", err)
 					continue
 				}
+				addMissing(missing)
+				uses = append(uses, found...)
+			}
+		}
+	}
+
+	// Sampled sidecar certificates, summarized per namespace
+	if *sampleWorkloads > 0 {
+		start := time.Now()
+		summary, err := sampleWorkloadCerts(kclient, ns, *sampleWorkloads, *envoyAdminPort, *dialTimeout, *workloadStaleRatio)
+		scan.timings.step("workload cert sampling", start)
+		if err != nil {
+			fmt.Fprintf(scan.out(), "error sampling workload certificates in namespace %s: %v\n", ns, err)
+			scan.recordError("pods", ns, err, false)
+		} else if summary != nil {
+			summary.Cluster = scan.cluster
+			scan.workloads = append(scan.workloads, *summary)
+			fmt.Fprintln(scan.out(), summary)
+		}
+	}
 
-				if len(secrets) > 0 {
-					// Analyze and print certificate expiration for each secret
-					for _, secret := range secrets {
-						expiryDate, err := analyzeCertificate(secret)
-						if err != nil {
-							fmt.Printf("error analyzing certificate for gateway %s in namespace %s: %v\n", gw.GetName(), ns, err)
-							continue
-						}
+	// Secrets without their own report timezone use the namespace's
+	var nsZone *time.Location
+	if len(uses) > 0 {
+		nsObj, err := kclient.CoreV1().Namespaces().Get(context.TODO(), ns, metav1.GetOptions{})
+		if err == nil {
+			nsZone = annotatedZone(nsObj)
+		} else {
+			debugf("Unable to get namespace %s for its report timezone: %v", ns, err)
+		}
+	}
 
-						fmt.Printf("Certificate %s in gateway %s in namespace %s expiration date is %s\n", secret.GetName(), gw.GetName(), ns, expiryDate)
+	// Analyze and print certificate expiration for each secret
+	report := func(secret corev1.Secret, r result) {
+		r.zone = nsZone
+		if lookup.isRestricted(secret) {
+			scan.reportRestricted(secret, r)
+			return
+		}
+		scan.reportSecret(secret, r)
+	}
+	if *dedupeBySecret {
+		for _, group := range groupBySecret(uses) {
+			r := result{Namespace: ns, Hosts: group.hosts}
+			for _, use := range group.uses {
+				for _, rev := range use.revisions {
+					if !slices.Contains(r.Revisions, rev) {
+						r.Revisions = append(r.Revisions, rev)
 					}
 				}
+				r.ReferencedBy = append(r.ReferencedBy, use.by)
+				r.clientCA = r.clientCA || use.clientCA
+				r.Ports = append(r.Ports, use.ports...)
+				r.References = append(r.References, use.refs...)
 			}
+			report(group.uses[0].secret, r)
+		}
+	} else {
+		for _, use := range uses {
+			report(use.secret, result{
+				Namespace:    ns,
+				ReferencedBy: []referrer{use.by},
+				Ports:        use.ports,
+				Hosts:        use.hosts,
+				Revisions:    use.revisions,
+				clientCA:     use.clientCA,
+			})
 		}
 	}
 
-	return nil
+	return rechecks
+}
+
+// Results of a namespace sorted by referencing object, then secret
+func sortResults(results []result) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if len(a.ReferencedBy) > 0 && len(b.ReferencedBy) > 0 && a.ReferencedBy[0] != b.ReferencedBy[0] {
+			if a.ReferencedBy[0].Kind != b.ReferencedBy[0].Kind {
+				return a.ReferencedBy[0].Kind > b.ReferencedBy[0].Kind
+			}
+			return a.ReferencedBy[0].Name < b.ReferencedBy[0].Name
+		}
+		return a.Secret < b.Secret
+	})
+}
+
+type secretGroup struct {
+	uses  []secretUse
+	hosts []string
+}
+
+// Secrets shared by several objects, reported once with --dedupe-by-secret
+func groupBySecret(uses []secretUse) []*secretGroup {
+	var groups []*secretGroup
+	byKey := map[string]*secretGroup{}
+	seenHosts := map[string]map[string]bool{}
+	for _, use := range uses {
+		key := use.secret.Namespace + "/" + use.secret.Name
+		group, ok := byKey[key]
+		if !ok {
+			group = &secretGroup{}
+			byKey[key] = group
+			seenHosts[key] = map[string]bool{}
+			groups = append(groups, group)
+		}
+		group.uses = append(group.uses, use)
+		for _, host := range use.hosts {
+			if !seenHosts[key][host] {
+				seenHosts[key][host] = true
+				group.hosts = append(group.hosts, host)
+			}
+		}
+	}
+
+	for _, group := range groups {
+		sort.Strings(group.hosts)
+	}
+
+	return groups
+}
+
+// Secrets whose data is forbidden are still listed from their metadata
+func (scan *scanContext) reportRestricted(secret corev1.Secret, r result) {
+	ns := r.Namespace
+	r.Secret = secret.GetName()
+	if secret.Namespace != ns {
+		r.SecretNamespace = secret.Namespace
+	}
+	r.ManagedBy = detectManager(secret, customManagers)
+	if *managedBy != "" && r.ManagedBy != *managedBy {
+		return
+	}
+	r.Status, r.Code = statusUnknown, findingContentForbidden
+
+	var referrers []string
+	for _, by := range r.ReferencedBy {
+		referrers = append(referrers, by.String())
+	}
+	line := fmt.Sprintf("Certificate %s in %s in namespace %s content not accessible, expiry unknown (secret created %s, managed by %s)", secret.GetName(), strings.Join(referrers, ", "), ns, secret.CreationTimestamp.UTC().Format(opensslTimeFormat), r.ManagedBy)
+	if s := findSilence(scan.silences, scan.cluster, ns, secret.GetName(), findingID(scan.cluster, r)); s != nil {
+		r.Silenced = s.String()
+		line += fmt.Sprintf(" (%s)", s)
+	}
+	r.text = line
+	scan.emit(r)
 }
 
-func getGatewaySecrets(kclient *kubernetes.Clientset, gw unstructured.Unstructured) ([]corev1.Secret, error) {
-	var secrets []corev1.Secret
+func (scan *scanContext) reportSecret(secret corev1.Secret, r result) {
+	ns := r.Namespace
+	r.Secret = secret.GetName()
+	if secret.Namespace != ns {
+		r.SecretNamespace = secret.Namespace
+	}
+
+	// Cross-namespace Gateway API references name the secret with its namespace
+	secretName := secret.GetName()
+	if r.SecretNamespace != "" {
+		secretName = r.SecretNamespace + "/" + secretName
+	}
+	var referrers []string
+	for _, by := range r.ReferencedBy {
+		referrers = append(referrers, by.String())
+	}
+	r.ManagedBy = detectManager(secret, customManagers)
+	if *managedBy != "" && r.ManagedBy != *managedBy {
+		return
+	}
+	r.certificate = secret.Annotations["cert-manager.io/certificate-name"]
+
+	start := time.Now()
+	finding, err := certs.EvaluateHosts(secret, r.Hosts, certs.EvalOptions{WarnDays: *warnDays, StaleInstallFraction: *staleInstallFraction, Now: clock(), VerifyChain: !r.clientCA})
+	scan.timings.step("cert analysis", start)
+	if err != nil {
+		r.setError(findingCertInvalid, err, fmt.Sprintf("error analyzing certificate for %s in namespace %s: %v", strings.Join(referrers, ", "), ns, err))
+		scan.emit(r)
+		return
+	}
+	r.setFinding(finding)
+
+	if zone := annotatedZone(&secret); zone != nil {
+		r.zone = zone
+	}
+	expiryDate := formatExpiry(finding.NotAfter, r.zone)
+	line := fmt.Sprintf("Certificate %s in %s in namespace %s expiration date is %s", secretName, referrers[0], ns, expiryDate)
+	if r.References != nil {
+		line = fmt.Sprintf("Certificate %s in namespace %s expiration date is %s, used by %d gateway servers", secretName, ns, expiryDate, len(r.References))
+	}
+
+	if len(r.Revisions) > 0 {
+		line += fmt.Sprintf(", served by revisions %s", strings.Join(r.Revisions, ", "))
+	}
+	if r.rechecked {
+		line += " (transiently missing, found on recheck)"
+	}
+	if *wide {
+		line += fmt.Sprintf(", managed by %s, id %s", r.ManagedBy, findingID(scan.cluster, r))
+	}
+	if scan.managers == nil {
+		scan.managers = map[string]int{}
+	}
+	scan.managers[r.ManagedBy]++
+
+	// Silenced secrets are still reported, only marked
+	if s := findSilence(scan.silences, scan.cluster, ns, secret.GetName(), findingID(scan.cluster, r)); s != nil {
+		r.Silenced = s.String()
+		line += fmt.Sprintf(" (%s)", s)
+	}
+	// Held back lines lose their cluster section, so name the cluster inline
+	if *top > 0 && (multiCluster()) {
+		line = fmt.Sprintf("[%s] %s", scan.cluster, line)
+	}
+	for _, ref := range r.References {
+		line += fmt.Sprintf("\n  referenced by %s", ref)
+	}
+	if scan.archive != nil {
+		scan.archive.addCertificate(scan.cluster, secret)
+	}
+	r.text = line
+	scan.emit(r)
+
+	chain := finding.Chain
+	for _, i := range finding.EarlyIntermediates {
+		fmt.Fprintf(scan.out(), "warning: intermediate certificate %d (%s) in secret %s in namespace %s expires on %s, before the leaf\n", i, chain[i].Subject.String(), secret.GetName(), ns, chain[i].NotAfter.UTC().Format(opensslTimeFormat))
+	}
+	for _, by := range r.ReferencedBy {
+		for _, host := range finding.UncoveredHosts {
+			fmt.Fprintf(scan.out(), "warning: host %s of %s in namespace %s not covered by the certificate in secret %s\n", host, by, ns, secretName)
+		}
+	}
+	if finding.ChainError != "" {
+		fmt.Fprintf(scan.out(), "warning: certificate in secret %s in namespace %s: %s\n", secretName, ns, finding.ChainError)
+	}
+	if finding.KeyError != "" {
+		fmt.Fprintf(scan.out(), "warning: private key in secret %s in namespace %s: %s\n", secretName, ns, finding.KeyError)
+	}
+	if finding.StaleInstall {
+		fmt.Fprintf(scan.out(), "warning: stale certificate installed in secret %s in namespace %s: written %s with %.0f%% of its lifetime left (valid from %s to %s)\n", secret.GetName(), ns, finding.InstalledAt.UTC().Format(opensslTimeFormat), finding.LifetimeLeft*100, chain[0].NotBefore.UTC().Format(opensslTimeFormat), chain[0].NotAfter.UTC().Format(opensslTimeFormat))
+	}
+
+	checkSecretHygiene(scan.out(), secret, *maxSecretSize, strings.Split(*extraSecretKeys, ","))
+
+	scan.drift.record(scan.cluster, secret)
+
+	if scan.exporter != nil {
+		for _, by := range r.ReferencedBy {
+			err = scan.exporter.export(scan.cluster, secret, by.Name)
+			if err != nil {
+				fmt.Fprintf(scan.out(), "error exporting certificate %s in namespace %s: %v\n", secret.GetName(), ns, err)
+				break
+			}
+		}
+	}
+
+	if *showChain {
+		printChain(scan.out(), chain, "  ")
+	}
+}
+
+// How an Istio gateway uses each of its secrets, one use per secret
+func istioGatewayUses(gw unstructured.Unstructured, secrets []corev1.Secret) []secretUse {
+	by := referrer{Kind: kindIstioGateway, Name: gw.GetName()}
+
+	var uses []secretUse
+	for _, secret := range secrets {
+		use := secretUse{
+			secret:   secret,
+			by:       by,
+			ports:    serverPortsForSecret(gw, secret.Name),
+			hosts:    gatewayHostsForSecret([]unstructured.Unstructured{gw}, secret.Name),
+			clientCA: mutualServerForSecret(gw, secret.Name),
+		}
+		for _, port := range use.ports {
+			use.refs = append(use.refs, fmt.Sprintf("%s server port %d", by, port))
+		}
+		uses = append(uses, use)
+	}
+
+	return uses
+}
+
+// On these servers the CA bundle of the credential verifies clients
+func mutualServerForSecret(gw unstructured.Unstructured, secretName string) bool {
+	servers, _, _ := unstructured.NestedSlice(gw.Object, "spec", "servers")
+	for _, serverObj := range servers {
+		server, ok := serverObj.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		credentialName, _, _ := unstructured.NestedString(server, "tls", "credentialName")
+		mode, _, _ := unstructured.NestedString(server, "tls", "mode")
+		if credentialName == secretName && (mode == "MUTUAL" || mode == "OPTIONAL_MUTUAL") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func serverPortsForSecret(gw unstructured.Unstructured, secretName string) []int64 {
+	var ports []int64
+	servers, _, _ := unstructured.NestedSlice(gw.Object, "spec", "servers")
+	for _, serverObj := range servers {
+		server, ok := serverObj.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		credentialName, _, _ := unstructured.NestedString(server, "tls", "credentialName")
+		if credentialName == secretName {
+			port, _, _ := unstructured.NestedInt64(server, "port", "number")
+			ports = append(ports, port)
+		}
+	}
+
+	return ports
+}
+
+// The result the secret would have had, reported as usual once found
+type recheck struct {
+	res             result
+	secretNamespace string
+}
+
+func (scan *scanContext) recheckMissingSecrets(kclient *kubernetes.Clientset, rechecks []recheck, delay time.Duration) {
+	if len(rechecks) == 0 {
+		return
+	}
+
+	// Give cert-manager time to recreate secrets deleted during a rotation
+	time.Sleep(delay)
+
+	for _, r := range rechecks {
+		res := r.res
+		secret, err := kclient.CoreV1().Secrets(r.secretNamespace).Get(context.TODO(), res.Secret, metav1.GetOptions{})
+		if err != nil {
+			code := findingSecretUnreadable
+			if apierrors.IsNotFound(err) {
+				code = findingSecretMissing
+			}
+			res.setError(code, err, fmt.Sprintf("error getting secret %s for %s in namespace %s: %v", res.Secret, res.ReferencedBy[0], res.Namespace, err))
+			scan.emit(res)
+			continue
+		}
+
+		res.rechecked = true
+		scan.reportSecret(*secret, res)
+	}
+}
+
+func getGatewaySecrets(lookup *secretLookup, gw unstructured.Unstructured) ([]corev1.Secret, []string, error) {
+	var (
+		secrets []corev1.Secret
+		missing []string
+		// Servers sharing a credential are one result, ports are merged by serverPortsForSecret
+		seen = map[string]bool{}
+	)
 
 	// Iterate over the gateway's servers
 	servers, found, err := unstructured.NestedSlice(gw.Object, "spec", "servers")
 	if !found || err != nil {
-		return nil, fmt.Errorf("error getting gateway servers: %v", err)
+		return nil, nil, fmt.Errorf("error getting gateway servers: %v", err)
 	}
 
 	for _, serverObj := range servers {
 		server, ok := serverObj.(map[string]interface{})
 		if !ok {
-			return nil, fmt.Errorf("invalid server object found")
+			return nil, nil, fmt.Errorf("invalid server object found")
 		}
 
 		// Check if the server has a secretName defined
@@ -154,47 +1422,24 @@ func getGatewaySecrets(kclient *kubernetes.Clientset, gw unstructured.Unstructur
 		if !found || err != nil {
 			continue // No credentialName found
 		}
+		if seen[credentialName] {
+			continue
+		}
+		seen[credentialName] = true
 
 		// Get the secret
-		secret, err := kclient.CoreV1().Secrets(gw.GetNamespace()).Get(context.TODO(), credentialName, metav1.GetOptions{})
+		secret, err := lookup.get(gw.GetNamespace(), credentialName)
+		if apierrors.IsNotFound(err) {
+			missing = append(missing, credentialName)
+			continue // Let the caller decide whether to recheck
+		}
 		if err != nil {
-			return nil, fmt.Errorf("error getting secret %s in namespace %s: %v", credentialName, gw.GetNamespace(), err)
+			return nil, nil, fmt.Errorf("error getting secret %s in namespace %s: %w", credentialName, gw.GetNamespace(), err)
 		}
 
 		// Append the secret to the list
 		secrets = append(secrets, *secret)
 	}
 
-	return secrets, nil
-}
-
-func analyzeCertificate(secret corev1.Secret) (string, error) {
-	// Extract the certificate data from the secret
-	certData, ok := secret.Data["tls.crt"]
-	if !ok {
-		return "", fmt.Errorf("tls.crt not found in secret")
-	}
-
-	// Remove begin and end
-	certData = bytes.ReplaceAll(certData, []byte("-----BEGIN CERTIFICATE-----\n"), []byte{})
-	certData = bytes.ReplaceAll(certData, []byte("\n-----END CERTIFICATE-----"), []byte{})
-
-	// Decode the base64-encoded certificate data
-	certBytes, err := base64.StdEncoding.DecodeString(string(certData))
-	if err != nil {
-		return "", fmt.Errorf("error decoding certificate data: %v", err)
-	}
-
-	// Execute OpenSSL command to analyze the certificate
-	opensslCmd := exec.Command("openssl", "x509", "-noout", "-enddate")
-	opensslCmd.Stdin = bytes.NewReader(certBytes)
-	output, err := opensslCmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("openssl error: %v", err)
-	}
-
-	// Extract the expiration date from the OpenSSL output
-	expiryDate := strings.TrimSpace(strings.TrimPrefix(string(output), "notAfter="))
-
-	return expiryDate, nil
+	return secrets, missing, nil
 }
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+)
+
+func TestGetGatewaySecrets(t *testing.T) {
+	server := func(port int64, mode, credential string) interface{} {
+		return map[string]interface{}{
+			"port":  map[string]interface{}{"number": port},
+			"hosts": []interface{}{"example.com"},
+			"tls":   map[string]interface{}{"mode": mode, "credentialName": credential},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		servers []interface{}
+		want    []string
+		ports   map[string][]int64
+	}{
+		{
+			name:    "one server",
+			servers: []interface{}{server(443, "SIMPLE", "a")},
+			want:    []string{"a"},
+			ports:   map[string][]int64{"a": {443}},
+		},
+		{
+			name:    "shared credential is one secret",
+			servers: []interface{}{server(443, "SIMPLE", "a"), server(8443, "MUTUAL", "a")},
+			want:    []string{"a"},
+			ports:   map[string][]int64{"a": {443, 8443}},
+		},
+		{
+			name:    "passthrough skipped",
+			servers: []interface{}{server(443, "PASSTHROUGH", "a"), server(8443, "SIMPLE", "b")},
+			want:    []string{"b"},
+			ports:   map[string][]int64{"b": {8443}},
+		},
+		{
+			name:    "two credentials",
+			servers: []interface{}{server(443, "SIMPLE", "a"), server(8443, "SIMPLE", "b"), server(9443, "SIMPLE", "a")},
+			want:    []string{"a", "b"},
+			ports:   map[string][]int64{"a": {443, 9443}, "b": {8443}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw := unstructured.Unstructured{Object: map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "gw", "namespace": "apps"},
+				"spec":     map[string]interface{}{"servers": tt.servers},
+			}}
+			lookup := newSecretLookup(nil)
+			for _, name := range []string{"a", "b"} {
+				lookup.secrets["apps/"+name] = &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps"}}
+			}
+
+			secrets, missing, err := getGatewaySecrets(lookup, gw)
+			if err != nil {
+				t.Fatalf("getGatewaySecrets() error = %v", err)
+			}
+			if len(missing) != 0 {
+				t.Errorf("missing = %v, want none", missing)
+			}
+			var got []string
+			for _, secret := range secrets {
+				got = append(got, secret.Name)
+				ports := serverPortsForSecret(gw, secret.Name)
+				if !slices.Equal(ports, tt.ports[secret.Name]) {
+					t.Errorf("ports of %s = %v, want %v", secret.Name, ports, tt.ports[secret.Name])
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("secrets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Serves the self-test secrets in every namespace, each with two gateways,
+// answering later namespaces first so workers finish out of order
+func parallelScanServer(t *testing.T, nsList []string, now time.Time) *httptest.Server {
+	cases := selfTestCases(now)
+	secrets := map[string]*corev1.Secret{}
+	var servers []interface{}
+	for i, tc := range cases {
+		servers = append(servers, map[string]interface{}{
+			"port":  map[string]interface{}{"number": int64(8443 + i), "name": "https-" + tc.secret, "protocol": "HTTPS"},
+			"hosts": tc.hosts,
+			"tls":   map[string]interface{}{"mode": "SIMPLE", "credentialName": tc.secret},
+		})
+		if tc.build == nil {
+			continue
+		}
+		secret, err := tc.build()
+		if err != nil {
+			t.Fatalf("building %s: %v", tc.name, err)
+		}
+		secrets[tc.secret] = secret
+	}
+
+	write := func(w http.ResponseWriter, code int, obj interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(obj)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		ns := ""
+		for i := range parts[:len(parts)-1] {
+			if parts[i] == "namespaces" {
+				ns = parts[i+1]
+			}
+		}
+		index := slices.Index(nsList, ns)
+		if index < 0 {
+			write(w, http.StatusNotFound, metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusFailure, Code: http.StatusNotFound, Reason: metav1.StatusReasonNotFound})
+			return
+		}
+
+		switch p := r.URL.Path; {
+		case strings.HasSuffix(p, "/namespaces/"+ns+"/gateways") && strings.HasPrefix(p, "/apis/"+gatewayResource.Group+"/"):
+			time.Sleep(time.Duration(len(nsList)-index) * 2 * time.Millisecond)
+			var items []interface{}
+			for _, name := range []string{"edge", "internal"} {
+				items = append(items, map[string]interface{}{
+					"apiVersion": gatewayResource.GroupVersion().String(),
+					"kind":       "Gateway",
+					"metadata":   map[string]interface{}{"name": name, "namespace": ns},
+					"spec":       map[string]interface{}{"servers": servers},
+				})
+			}
+			write(w, http.StatusOK, map[string]interface{}{"apiVersion": gatewayResource.GroupVersion().String(), "kind": "GatewayList", "metadata": map[string]interface{}{}, "items": items})
+		case strings.Contains(p, "/namespaces/"+ns+"/secrets/"):
+			secret, ok := secrets[parts[len(parts)-1]]
+			if !ok {
+				write(w, http.StatusNotFound, metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusFailure, Code: http.StatusNotFound, Reason: metav1.StatusReasonNotFound, Message: "secret not found"})
+				return
+			}
+			copied := secret.DeepCopy()
+			copied.TypeMeta = metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"}
+			copied.Namespace = ns
+			write(w, http.StatusOK, copied)
+		default:
+			write(w, http.StatusNotFound, metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusFailure, Code: http.StatusNotFound, Reason: metav1.StatusReasonNotFound})
+		}
+	}))
+}
+
+func TestParallelScanDeterministic(t *testing.T) {
+	now := time.Now()
+	defer func(workers int, recheck bool) {
+		*concurrency, *noRecheck, clock = workers, recheck, time.Now
+	}(*concurrency, *noRecheck)
+	*noRecheck, clock = true, func() time.Time { return now }
+
+	var nsList []string
+	for i := 0; i < 12; i++ {
+		nsList = append(nsList, fmt.Sprintf("team-%02d", i))
+	}
+	srv := parallelScanServer(t, nsList, now)
+	defer srv.Close()
+
+	scanWith := func(workers int) (string, string, string) {
+		t.Helper()
+		*concurrency = workers
+		apiBudget.used.Store(0)
+		kclient, dclient, err := newClients(&rest.Config{Host: srv.URL, QPS: -1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		scan := &scanContext{buffered: true, drift: newDriftTracker(nil), sources: map[string]bool{sourceIstio: true}}
+		if err := getNsGateways(kclient, dclient, nsList, scan); err != nil {
+			t.Fatalf("getNsGateways() error = %v", err)
+		}
+		var jsonOut, tableOut bytes.Buffer
+		if err := renderJSON(&jsonOut, newReport(scan, scan.results)); err != nil {
+			t.Fatal(err)
+		}
+		if err := renderTable(&tableOut, newReport(scan, scan.results)); err != nil {
+			t.Fatal(err)
+		}
+		var text []string
+		for _, r := range scan.results {
+			text = append(text, r.text)
+		}
+
+		return jsonOut.String(), tableOut.String(), scan.output.String() + strings.Join(text, "\n")
+	}
+
+	wantJSON, wantTable, wantText := scanWith(1)
+	if !strings.Contains(wantJSON, "team-11") {
+		t.Fatalf("sequential scan missed namespaces: %s", wantJSON)
+	}
+	for run := 0; run < 3; run++ {
+		gotJSON, gotTable, gotText := scanWith(16)
+		if gotJSON != wantJSON {
+			t.Errorf("run %d: JSON report with 16 workers differs from 1 worker", run)
+		}
+		if gotTable != wantTable {
+			t.Errorf("run %d: table report with 16 workers differs from 1 worker", run)
+		}
+		if gotText != wantText {
+			t.Errorf("run %d: text output with 16 workers differs from 1 worker", run)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const unmanaged = "manual"
+
+type managerRule struct {
+	name          string
+	ownerKinds    []string
+	keyPrefixes   []string // label and annotation keys
+	fieldManagers []string // managedFields manager prefixes
+}
+
+var defaultManagerRules = []managerRule{
+	{
+		name:          "cert-manager",
+		ownerKinds:    []string{"Certificate", "CertificateRequest"},
+		keyPrefixes:   []string{"cert-manager.io/", "controller.cert-manager.io/"},
+		fieldManagers: []string{"cert-manager"},
+	},
+	{
+		name:          "external-secrets",
+		ownerKinds:    []string{"ExternalSecret", "PushSecret"},
+		keyPrefixes:   []string{"external-secrets.io/", "reconcile.external-secrets.io/"},
+		fieldManagers: []string{"external-secrets"},
+	},
+	{
+		name:          "istio",
+		ownerKinds:    []string{"IstioOperator"},
+		keyPrefixes:   []string{"install.operator.istio.io/"},
+		fieldManagers: []string{"istio-operator", "pilot-discovery"},
+	},
+	{
+		name:          "sealed-secrets",
+		ownerKinds:    []string{"SealedSecret"},
+		keyPrefixes:   []string{"sealedsecrets.bitnami.com/"},
+		fieldManagers: []string{"sealed-secrets"},
+	},
+}
+
+type managerRules []managerRule
+
+func (r *managerRules) String() string {
+	var names []string
+	for _, rule := range *r {
+		names = append(names, rule.name)
+	}
+
+	return strings.Join(names, ",")
+}
+
+func (r *managerRules) Set(value string) error {
+	name, match, ok := strings.Cut(value, "=")
+	if !ok || name == "" || match == "" {
+		return fmt.Errorf("expected NAME=MATCH")
+	}
+
+	// In-house operators are matched by label/annotation prefix, owner kind or field manager
+	*r = append(*r, managerRule{
+		name:          name,
+		ownerKinds:    []string{match},
+		keyPrefixes:   []string{match},
+		fieldManagers: []string{match},
+	})
+
+	return nil
+}
+
+func (rule managerRule) matches(secret corev1.Secret) bool {
+	for _, owner := range secret.OwnerReferences {
+		for _, kind := range rule.ownerKinds {
+			if owner.Kind == kind {
+				return true
+			}
+		}
+	}
+
+	for _, keys := range []map[string]string{secret.Labels, secret.Annotations} {
+		for key := range keys {
+			for _, prefix := range rule.keyPrefixes {
+				if strings.HasPrefix(key, prefix) {
+					return true
+				}
+			}
+		}
+	}
+
+	for _, entry := range secret.ManagedFields {
+		for _, prefix := range rule.fieldManagers {
+			if strings.HasPrefix(entry.Manager, prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func detectManager(secret corev1.Secret, custom []managerRule) string {
+	// Custom rules take precedence over the built-in ones
+	for _, rules := range [][]managerRule{custom, defaultManagerRules} {
+		for _, rule := range rules {
+			if rule.matches(secret) {
+				return rule.name
+			}
+		}
+	}
+
+	return unmanaged
+}
+
+func printManagerCounts(counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+
+	var names []string
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%d", name, counts[name]))
+	}
+	fmt.Printf("Certificates per manager: %s\n", strings.Join(parts, ", "))
+}
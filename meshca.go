@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Subset of Istio's MeshConfig we need
+type meshConfig struct {
+	CACertificates []meshCACertificate `json:"caCertificates"`
+}
+
+type meshCACertificate struct {
+	PEM             string `json:"pem"`
+	SpiffeBundleURL string `json:"spiffeBundleUrl"`
+}
+
+func parseMeshConfigRef(ref string) (string, string, error) {
+	ns, name, ok := strings.Cut(ref, "/")
+	if !ok || ns == "" || name == "" {
+		return "", "", fmt.Errorf("invalid mesh config %q, expected NAMESPACE/NAME", ref)
+	}
+
+	return ns, name, nil
+}
+
+// Anchors are either inline PEM, a configmap://NAME[/KEY] or secret://NAME[/KEY]
+// reference resolved in the mesh config namespace, or a path inside the proxy image
+func meshAnchorData(kclient *kubernetes.Clientset, ns string, ref string) (map[string][]byte, error) {
+	kind, target, _ := strings.Cut(ref, "://")
+	name, key, _ := strings.Cut(target, "/")
+
+	data := map[string][]byte{}
+	switch kind {
+	case "configmap":
+		cm, err := kclient.CoreV1().ConfigMaps(ns).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to get configmap %s: %v", name, err)
+		}
+		for k, v := range cm.Data {
+			data[k] = []byte(v)
+		}
+		for k, v := range cm.BinaryData {
+			data[k] = v
+		}
+	case "secret":
+		secret, err := kclient.CoreV1().Secrets(ns).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to get secret %s: %v", name, err)
+		}
+		data = secret.Data
+	default:
+		return nil, fmt.Errorf("unsupported reference %q", ref)
+	}
+
+	if key == "" {
+		return data, nil
+	}
+	value, ok := data[key]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in %s %s", key, kind, name)
+	}
+
+	return map[string][]byte{key: value}, nil
+}
+
+func printAnchors(source string, data []byte) {
+	parsed, err := certs.ParseCertificates(data)
+	if err != nil {
+		fmt.Printf("error analyzing trust anchor %s: %v\n", source, err)
+		return
+	}
+
+	for _, cert := range parsed {
+		fmt.Printf("Trust anchor %s (%s) expiration date is %s\n", source, cert.Subject.String(), cert.NotAfter.UTC().Format(opensslTimeFormat))
+	}
+}
+
+func checkMeshCACertificates(kclient *kubernetes.Clientset, ref string) error {
+	ns, name, err := parseMeshConfigRef(ref)
+	if err != nil {
+		return err
+	}
+
+	cm, err := kclient.CoreV1().ConfigMaps(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get mesh config %s: %v", ref, err)
+	}
+
+	var mesh meshConfig
+	err = yaml.Unmarshal([]byte(cm.Data["mesh"]), &mesh)
+	if err != nil {
+		return fmt.Errorf("unable to parse meshConfig in %s: %v", ref, err)
+	}
+
+	fmt.Println("Mesh trust anchors:")
+	if len(mesh.CACertificates) == 0 {
+		fmt.Printf("No caCertificates configured in %s\n", ref)
+		return nil
+	}
+
+	for i, entry := range mesh.CACertificates {
+		pem := strings.TrimSpace(entry.PEM)
+		switch {
+		case entry.SpiffeBundleURL != "":
+			fmt.Printf("Trust anchor %d is fetched from %s, not checked\n", i, entry.SpiffeBundleURL)
+		case strings.HasPrefix(pem, "-----BEGIN"):
+			printAnchors(fmt.Sprintf("%d", i), []byte(pem))
+		case strings.HasPrefix(pem, "/"):
+			// Only the proxy can see files baked into its image
+			fmt.Printf("Trust anchor %d is file %s inside the proxy image, not checked\n", i, pem)
+		default:
+			data, err := meshAnchorData(kclient, ns, pem)
+			if err != nil {
+				fmt.Printf("error resolving trust anchor %d: %v\n", i, err)
+				continue
+			}
+			var keys []string
+			for key := range data {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				printAnchors(fmt.Sprintf("%s[%s]", pem, key), data[key])
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Longest list sent in one message, the rest is counted
+const notifyMaxFindings = 50
+
+type notifier interface {
+	name() string
+	// Body POSTed for n
+	payload(n notification) interface{}
+	send(n notification) error
+}
+
+type notification struct {
+	Summary  string   `json:"summary"`
+	Findings []result `json:"findings"`
+}
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+func postJSON(url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := notifyClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// Posts the summary and the findings as they appear in the JSON report
+type webhookNotifier struct {
+	url string
+}
+
+func (w webhookNotifier) name() string { return "webhook" }
+
+func (w webhookNotifier) payload(n notification) interface{} { return n }
+
+func (w webhookNotifier) send(n notification) error {
+	return postJSON(w.url, w.payload(n))
+}
+
+// Slack incoming webhooks only take the text
+type slackNotifier struct {
+	url string
+}
+
+func (s slackNotifier) name() string { return "slack" }
+
+func (s slackNotifier) payload(n notification) interface{} {
+	return map[string]string{"text": n.Summary}
+}
+
+func (s slackNotifier) send(n notification) error {
+	return postJSON(s.url, s.payload(n))
+}
+
+// Findings already notified, to only notify again when they change
+type notifyState struct {
+	mu   sync.Mutex
+	file string
+	ids  map[string]bool
+	// False until a first set of findings was notified or loaded
+	known bool
+}
+
+func loadNotifyState(file string) (*notifyState, error) {
+	state := &notifyState{file: file, ids: map[string]bool{}}
+	if file == "" {
+		return state, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read notification state: %v", err)
+	}
+	var ids []string
+	err = json.Unmarshal(data, &ids)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification state %s: %v", file, err)
+	}
+	for _, id := range ids {
+		state.ids[id] = true
+	}
+	state.known = true
+
+	return state, nil
+}
+
+func (s *notifyState) changed(findings []result) bool {
+	if !s.known || len(findings) != len(s.ids) {
+		return true
+	}
+	for _, r := range findings {
+		if !s.ids[r.ID] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *notifyState) save(findings []result) error {
+	s.ids, s.known = map[string]bool{}, true
+	var ids []string
+	for _, r := range findings {
+		s.ids[r.ID] = true
+		ids = append(ids, r.ID)
+	}
+	sort.Strings(ids)
+	if s.file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.file, append(data, '\n'), 0o644)
+}
+
+// Same findings that fail the run: silenced and baseline ones are left out
+func notifiable(results []result) []result {
+	var findings []result
+	for _, r := range results {
+		if r.Status != statusOK && r.Silenced == "" && !r.Baseline {
+			findings = append(findings, r)
+		}
+	}
+
+	return findings
+}
+
+func notificationSummary(findings []result) string {
+	if len(findings) == 0 {
+		return "check-secrets: all certificates are fine again"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "check-secrets: %d certificates need attention", len(findings))
+	for i, r := range findings {
+		if i == notifyMaxFindings {
+			fmt.Fprintf(&b, "\n…and %d more", len(findings)-i)
+			break
+		}
+
+		var referrers []string
+		for _, by := range r.ReferencedBy {
+			referrers = append(referrers, by.String())
+		}
+		where := r.Namespace
+		if r.Cluster != "" {
+			where = r.Cluster + "/" + where
+		}
+		secret := r.Secret
+		if r.SecretNamespace != "" {
+			secret = r.SecretNamespace + "/" + secret
+		}
+		fmt.Fprintf(&b, "\n• %s %s %s secret %s", r.Code, where, strings.Join(referrers, ", "), secret)
+		if r.NotAfter != nil {
+			fmt.Fprintf(&b, " expires %s (%d days)", r.NotAfter.UTC().Format(time.RFC3339), *r.DaysRemaining)
+		}
+		if r.Error != "" {
+			fmt.Fprintf(&b, ": %s", r.Error)
+		}
+	}
+
+	return b.String()
+}
+
+// Failures are reported but never fail the scan, the state only moves on once every notifier got it
+func notify(notifiers []notifier, state *notifyState, onChange, dryRun bool, results []result) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	findings := notifiable(results)
+	if onChange && !state.changed(findings) {
+		debugf("Findings unchanged since the last notification, not notifying")
+		return
+	}
+	// Nothing to resolve on a first run without findings
+	if len(findings) == 0 && (!state.known || len(state.ids) == 0) {
+		return
+	}
+
+	n := notification{Summary: notificationSummary(findings), Findings: findings}
+	if n.Findings == nil {
+		n.Findings = []result{}
+	}
+	failed := false
+	for _, nt := range notifiers {
+		if dryRun {
+			body, err := json.MarshalIndent(nt.payload(n), "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error encoding the %s notification: %v\n", nt.name(), err)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "dry-run: would notify %s of %d findings with:\n%s\n", nt.name(), len(findings), body)
+			continue
+		}
+		err := nt.send(n)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error notifying %s: %v\n", nt.name(), err)
+			failed = true
+		}
+	}
+	if failed || dryRun {
+		return
+	}
+
+	err := state.save(findings)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error saving the notification state: %v\n", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNotifierPayloads(t *testing.T) {
+	n := notification{
+		Summary:  "check-secrets: 1 certificates need attention",
+		Findings: []result{{ID: "v1-0123456789abcdef", Namespace: "apps", Secret: "cert", Code: findingCertExpired}},
+	}
+
+	tests := []struct {
+		name     string
+		notifier func(url string) notifier
+		want     string
+	}{
+		{
+			name:     "slack",
+			notifier: func(url string) notifier { return slackNotifier{url: url} },
+			want:     `{"text":"check-secrets: 1 certificates need attention"}`,
+		},
+		{
+			name:     "webhook",
+			notifier: func(url string) notifier { return webhookNotifier{url: url} },
+			want:     `{"summary":"check-secrets: 1 certificates need attention","findings":[{"id":"v1-0123456789abcdef","namespace":"apps","referencedBy":null,"secret":"cert","status":"","code":"CERT_EXPIRED"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				got = string(body)
+			}))
+			defer server.Close()
+
+			nt := tt.notifier(server.URL)
+			if err := nt.send(n); err != nil {
+				t.Fatalf("send() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("posted %s, want %s", got, tt.want)
+			}
+
+			// The dry-run body is the one that is posted
+			payload, err := json.Marshal(nt.payload(n))
+			if err != nil {
+				t.Fatalf("payload() error = %v", err)
+			}
+			if string(payload) != got {
+				t.Errorf("payload() = %s, posted %s", payload, got)
+			}
+		})
+	}
+}
+
+func TestNotifyDryRun(t *testing.T) {
+	results := []result{{ID: "v1-0123456789abcdef", Namespace: "apps", Secret: "cert", Status: statusExpired, Code: findingCertExpired}}
+
+	tests := []struct {
+		name      string
+		dryRun    bool
+		wantPosts int32
+	}{
+		{name: "dry run", dryRun: true, wantPosts: 0},
+		{name: "sent", dryRun: false, wantPosts: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var posts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				posts.Add(1)
+			}))
+			defer server.Close()
+
+			stateFile := filepath.Join(t.TempDir(), "state.json")
+			state, err := loadNotifyState(stateFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			notify([]notifier{slackNotifier{url: server.URL}, webhookNotifier{url: server.URL}}, state, true, tt.dryRun, results)
+
+			if got := posts.Load(); got != tt.wantPosts {
+				t.Errorf("notifiers posted %d times, want %d", got, tt.wantPosts)
+			}
+			_, err = os.Stat(stateFile)
+			if tt.dryRun && !os.IsNotExist(err) {
+				t.Errorf("dry run wrote the notification state (stat error %v)", err)
+			}
+			if !tt.dryRun && err != nil {
+				t.Errorf("notification state not written: %v", err)
+			}
+			if state.known != !tt.dryRun {
+				t.Errorf("state known = %v after notifying, want %v", state.known, !tt.dryRun)
+			}
+		})
+	}
+}
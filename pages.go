@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Large lists are read in pages of --page-size so no single response holds them all
+func listPages(opts metav1.ListOptions, page func(metav1.ListOptions) (string, error)) error {
+	opts.Limit = *pageSize
+	for {
+		next, err := page(opts)
+		if err != nil || next == "" {
+			return err
+		}
+		opts.Continue = next
+	}
+}
+
+func listObjects(resource dynamic.ResourceInterface, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	all := &unstructured.UnstructuredList{}
+	err := listPages(opts, func(opts metav1.ListOptions) (string, error) {
+		list, err := resource.List(context.TODO(), opts)
+		if err != nil {
+			return "", err
+		}
+		all.Items = append(all.Items, list.Items...)
+		return list.GetContinue(), nil
+	})
+
+	return all, err
+}
+
+func listNamespaces(kclient *kubernetes.Clientset, opts metav1.ListOptions) (*corev1.NamespaceList, error) {
+	all := &corev1.NamespaceList{}
+	err := listPages(opts, func(opts metav1.ListOptions) (string, error) {
+		list, err := kclient.CoreV1().Namespaces().List(context.TODO(), opts)
+		if err != nil {
+			return "", err
+		}
+		all.Items = append(all.Items, list.Items...)
+		return list.Continue, nil
+	})
+
+	return all, err
+}
+
+func listSecrets(kclient *kubernetes.Clientset, ns string, opts metav1.ListOptions) (*corev1.SecretList, error) {
+	all := &corev1.SecretList{}
+	err := listPages(opts, func(opts metav1.ListOptions) (string, error) {
+		list, err := kclient.CoreV1().Secrets(ns).List(context.TODO(), opts)
+		if err != nil {
+			return "", err
+		}
+		all.Items = append(all.Items, list.Items...)
+		return list.Continue, nil
+	})
+
+	return all, err
+}
+
+func listPods(kclient *kubernetes.Clientset, ns string, opts metav1.ListOptions) (*corev1.PodList, error) {
+	all := &corev1.PodList{}
+	err := listPages(opts, func(opts metav1.ListOptions) (string, error) {
+		list, err := kclient.CoreV1().Pods(ns).List(context.TODO(), opts)
+		if err != nil {
+			return "", err
+		}
+		all.Items = append(all.Items, list.Items...)
+		return list.Continue, nil
+	})
+
+	return all, err
+}
+
+func listIngresses(kclient *kubernetes.Clientset, ns string, opts metav1.ListOptions) (*networkingv1.IngressList, error) {
+	all := &networkingv1.IngressList{}
+	err := listPages(opts, func(opts metav1.ListOptions) (string, error) {
+		list, err := kclient.NetworkingV1().Ingresses(ns).List(context.TODO(), opts)
+		if err != nil {
+			return "", err
+		}
+		all.Items = append(all.Items, list.Items...)
+		return list.Continue, nil
+	})
+
+	return all, err
+}
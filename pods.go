@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+func getGatewayPods(kclient *kubernetes.Clientset, gw unstructured.Unstructured) ([]corev1.Pod, error) {
+	selector, found, err := unstructured.NestedStringMap(gw.Object, "spec", "selector")
+	if !found || err != nil || len(selector) == 0 {
+		return nil, fmt.Errorf("gateway %s has no workload selector", gw.GetName())
+	}
+
+	// Istio matches the selector against workloads in every namespace
+	podList, err := listPods(kclient, metav1.NamespaceAll, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing pods for gateway %s: %v", gw.GetName(), err)
+	}
+
+	return podList.Items, nil
+}
+
+func isFileMountGateway(gw unstructured.Unstructured) bool {
+	servers, _, _ := unstructured.NestedSlice(gw.Object, "spec", "servers")
+	for _, serverObj := range servers {
+		server, ok := serverObj.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// Servers reading the certificate from a mounted file instead of SDS
+		serverCert, _, _ := unstructured.NestedString(server, "tls", "serverCertificate")
+		credentialName, _, _ := unstructured.NestedString(server, "tls", "credentialName")
+		if serverCert != "" && credentialName == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkGatewayPodRestarts(w io.Writer, kclient *kubernetes.Clientset, gw unstructured.Unstructured, secrets []corev1.Secret) error {
+	pods, err := getGatewayPods(kclient, gw)
+	if err != nil {
+		return err
+	}
+
+	fileMount := isFileMountGateway(gw)
+	mounted := map[string]*corev1.Secret{}
+
+	for _, pod := range pods {
+		if pod.Status.StartTime == nil {
+			continue // Pod not started yet
+		}
+
+		podSecrets := secrets
+		if fileMount {
+			// File-mount gateways read the secrets mounted as volumes in the pod
+			podSecrets = nil
+			for _, volume := range pod.Spec.Volumes {
+				if volume.Secret == nil {
+					continue
+				}
+
+				key := pod.Namespace + "/" + volume.Secret.SecretName
+				secret, ok := mounted[key]
+				if !ok {
+					secret, err = kclient.CoreV1().Secrets(pod.Namespace).Get(context.TODO(), volume.Secret.SecretName, metav1.GetOptions{})
+					if err != nil {
+						// The client returns an empty secret on errors, never compare against it
+						fmt.Fprintf(w, "error getting secret %s mounted by pod %s in namespace %s: %v\n", volume.Secret.SecretName, pod.Name, pod.Namespace, err)
+						secret = nil
+					}
+					mounted[key] = secret
+				}
+				if secret != nil {
+					podSecrets = append(podSecrets, *secret)
+				}
+			}
+		}
+
+		for _, secret := range podSecrets {
+			modified := certs.LastModified(secret)
+			if pod.Status.StartTime.Time.Before(modified) {
+				fmt.Fprintf(w, "Pod %s in namespace %s for gateway %s started at %s, before secret %s was last modified at %s\n", pod.Name, pod.Namespace, gw.GetName(), pod.Status.StartTime.Time.Format(time.RFC3339), secret.GetName(), modified.Format(time.RFC3339))
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestCheckGatewayPodRestartsFileMount(t *testing.T) {
+	started := metav1.NewTime(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	rotated := metav1.NewTime(started.AddDate(0, 0, 1))
+	pod := func(name string, volumes ...string) corev1.Pod {
+		p := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "istio-system"}, Status: corev1.PodStatus{StartTime: &started}}
+		for _, secret := range volumes {
+			p.Spec.Volumes = append(p.Spec.Volumes, corev1.Volume{Name: secret, VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: secret}}})
+		}
+		return p
+	}
+	pods := corev1.PodList{
+		TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"},
+		Items:    []corev1.Pod{pod("ingress-a", "rotated", "gone"), pod("ingress-b", "rotated", "gone")},
+	}
+
+	gets := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch p := r.URL.Path; {
+		case p == "/api/v1/pods":
+			json.NewEncoder(w).Encode(pods)
+		case strings.HasPrefix(p, "/api/v1/namespaces/istio-system/secrets/"):
+			name := strings.TrimPrefix(p, "/api/v1/namespaces/istio-system/secrets/")
+			gets[name]++
+			if name != "rotated" {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusFailure, Code: http.StatusNotFound, Reason: metav1.StatusReasonNotFound, Message: `secrets "` + name + `" not found`})
+				return
+			}
+			json.NewEncoder(w).Encode(corev1.Secret{TypeMeta: metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"}, ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "istio-system", CreationTimestamp: rotated}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	kclient, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL, QPS: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gw := unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "ingress", "namespace": "istio-system"},
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{"istio": "ingressgateway"},
+			"servers": []interface{}{map[string]interface{}{
+				"port": map[string]interface{}{"number": int64(443)},
+				"tls":  map[string]interface{}{"mode": "SIMPLE", "serverCertificate": "/etc/certs/tls.crt"},
+			}},
+		},
+	}}
+
+	var out bytes.Buffer
+	if err := checkGatewayPodRestarts(&out, kclient, gw, nil); err != nil {
+		t.Fatalf("checkGatewayPodRestarts() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		"error getting secret gone mounted by pod ingress-a",
+		"Pod ingress-a in namespace istio-system for gateway ingress started at 2024-06-01T00:00:00Z, before secret rotated was last modified at 2024-06-02T00:00:00Z",
+		"Pod ingress-b in namespace istio-system for gateway ingress started at 2024-06-01T00:00:00Z, before secret rotated was last modified at 2024-06-02T00:00:00Z",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("output = %q, want %d lines", out.String(), len(want))
+	}
+	for i := range want {
+		if !strings.HasPrefix(lines[i], want[i]) {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+	if gets["rotated"] != 1 || gets["gone"] != 1 {
+		t.Errorf("secret gets = %v, want each mounted secret fetched once", gets)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	"sigs.k8s.io/yaml"
+)
+
+// Result statuses, worst last
+const (
+	statusOK      = certs.StatusOK
+	statusWarning = certs.StatusWarning
+	statusUnknown = "unknown"
+	statusExpired = certs.StatusExpired
+	statusError   = "error"
+)
+
+// Exit codes for --warn-days and failed checks
+const (
+	exitWarning  = 2
+	exitCritical = 3
+)
+
+// One certificate, or one secret that couldn't be checked, found during the scan
+type result struct {
+	ID              string                  `json:"id"`
+	Cluster         string                  `json:"cluster,omitempty"`
+	Namespace       string                  `json:"namespace"`
+	ReferencedBy    []referrer              `json:"referencedBy"`
+	Ports           []int64                 `json:"ports,omitempty"`
+	Hosts           []string                `json:"hosts,omitempty"`
+	Secret          string                  `json:"secret,omitempty"`
+	SecretNamespace string                  `json:"secretNamespace,omitempty"`
+	NotAfter        *time.Time              `json:"notAfter,omitempty"`
+	DaysRemaining   *int                    `json:"daysRemaining,omitempty"`
+	ManagedBy       string                  `json:"managedBy,omitempty"`
+	Silenced        string                  `json:"silenced,omitempty"`
+	Baseline        bool                    `json:"baseline,omitempty"`
+	References      []string                `json:"references,omitempty"`
+	Revisions       []string                `json:"revisions,omitempty"`
+	Chain           []certs.CertificateInfo `json:"chain,omitempty"`
+	UncoveredHosts  []string                `json:"uncoveredHosts,omitempty"`
+	Status          string                  `json:"status"`
+	Code            string                  `json:"code"`
+	Hint            string                  `json:"hint,omitempty"`
+	Error           string                  `json:"error,omitempty"`
+
+	// Line printed in text mode
+	text string
+
+	// Extra zone for human-readable output, structured output stays UTC
+	zone *time.Location
+
+	// The secret's CA bundle verifies clients, not its own chain
+	clientCA bool
+
+	// Missing during the scan, found when checked again at the end
+	rechecked bool
+
+	// cert-manager Certificate issuing the secret, for the hint
+	certificate string
+}
+
+func (r *result) setFinding(f certs.Finding) {
+	r.NotAfter = &f.NotAfter
+	r.DaysRemaining = &f.DaysRemaining
+	r.Status = f.Status
+	r.Chain = certs.Describe(f.Chain)
+	r.UncoveredHosts = f.UncoveredHosts
+
+	// One code per result, the problem breaking the most traffic wins
+	switch {
+	case f.Status == statusExpired:
+		r.Code = findingCertExpired
+	case f.KeyError != "":
+		r.Status, r.Code, r.Error = statusError, findingKeyMismatch, f.KeyError
+	case f.ChainError != "":
+		r.Status, r.Code, r.Error = statusError, findingChainInvalid, f.ChainError
+	case len(f.UncoveredHosts) > 0:
+		r.Status, r.Code, r.Error = statusError, findingHostMismatch, "hosts not covered by the certificate: "+strings.Join(f.UncoveredHosts, ", ")
+	case f.Status == statusWarning:
+		r.Code = findingCertExpiring
+	default:
+		r.Code = findingCertOK
+	}
+}
+
+func (r *result) setError(code string, err error, text string) {
+	r.Status = statusError
+	r.Code = code
+	r.Error = err.Error()
+	r.text = text
+}
+
+// Text mode prints as the scan goes, other formats render once it is done
+func (scan *scanContext) emit(r result) {
+	r.Cluster = scan.cluster
+	r.ID = findingID(scan.cluster, r)
+	r.Hint = findingHint(r)
+	if scan.archive != nil {
+		scan.archive.link(r)
+	}
+	if scan.baseline[r.ID] {
+		r.Baseline = true
+		// Marked on the first line, before any referenced by lines
+		first, rest, found := strings.Cut(r.text, "\n")
+		r.text = first + " (baseline)"
+		if found {
+			r.text += "\n" + rest
+		}
+	}
+	scan.results = append(scan.results, r)
+
+	if !scan.buffered {
+		scan.printResult(r)
+	}
+}
+
+func (scan *scanContext) printResult(r result) {
+	if output == "text" && (*top == 0 || r.NotAfter == nil) {
+		fmt.Println(r.text)
+	}
+}
+
+func selectTop(results []result, n int) ([]result, int) {
+	// Certificates without a date are never held back
+	var dated, kept []result
+	for _, r := range results {
+		if r.NotAfter == nil {
+			kept = append(kept, r)
+		} else {
+			dated = append(dated, r)
+		}
+	}
+	sort.SliceStable(dated, func(i, j int) bool { return dated[i].NotAfter.Before(*dated[j].NotAfter) })
+
+	// Ties with the last shown certificate are shown too
+	shown := len(dated)
+	if n < shown {
+		shown = n
+		last := *dated[n-1].DaysRemaining
+		for shown < len(dated) && *dated[shown].DaysRemaining == last {
+			shown++
+		}
+	}
+
+	return append(kept, dated[:shown]...), len(dated)
+}
+
+func exitCode(results []result) int {
+	code := 0
+	for _, r := range results {
+		// Silenced and baseline findings are known and accepted
+		if r.Silenced != "" || r.Baseline {
+			continue
+		}
+		if *critDays > 0 && r.DaysRemaining != nil && *r.DaysRemaining < *critDays {
+			return exitCritical
+		}
+
+		switch r.Status {
+		case statusExpired, statusError:
+			return exitCritical
+		case statusWarning, statusUnknown:
+			code = exitWarning
+		}
+	}
+
+	return code
+}
+
+type report struct {
+	Results   []result          `json:"results"`
+	Errors    []scanError       `json:"errors,omitempty"`
+	Clusters  []clusterStatus   `json:"clusters,omitempty"`
+	Workloads []workloadSummary `json:"workloadCertificates,omitempty"`
+	Disabled  []disabledSource  `json:"disabledSources,omitempty"`
+	Partial   bool              `json:"partial"`
+
+	// Set when --top left out dated certificates, out of TotalDated
+	Truncated  bool `json:"truncated,omitempty"`
+	TotalDated int  `json:"totalDated,omitempty"`
+
+	// Side effects such as the certificate export were skipped
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Set when --as-of evaluated the scan at another instant
+	AsOf *time.Time `json:"asOf,omitempty"`
+
+	// Made across all clusters, as counted for --max-api-requests
+	APIRequests int64 `json:"apiRequests,omitempty"`
+}
+
+func newReport(scan *scanContext, results []result) report {
+	r := report{Results: results, Errors: scan.errors, Clusters: scan.clusters, Workloads: scan.workloads, Disabled: scan.disabled, DryRun: *dryRun, APIRequests: apiBudget.used.Load()}
+	if r.Results == nil {
+		r.Results = []result{}
+	}
+	for _, e := range scan.errors {
+		r.Partial = r.Partial || e.Partial
+	}
+	if *asOf != "" {
+		t := clock()
+		r.AsOf = &t
+	}
+
+	return r
+}
+
+func renderJSON(w io.Writer, r report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func renderYAML(w io.Writer, r report) error {
+	data, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func renderTable(w io.Writer, r report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCLUSTER\tNAMESPACE\tREFERENCED BY\tSECRET\tNOT AFTER\tDAYS\tSTATUS")
+	for _, res := range r.Results {
+		notAfter, days := "-", "-"
+		if res.NotAfter != nil {
+			notAfter = res.NotAfter.UTC().Format(time.RFC3339)
+			if res.zone != nil && res.zone != time.UTC {
+				notAfter += " / " + res.NotAfter.In(res.zone).Format("2006-01-02 15:04 MST")
+			}
+			days = fmt.Sprintf("%d", *res.DaysRemaining)
+		}
+		var referrers []string
+		for _, by := range res.ReferencedBy {
+			referrers = append(referrers, by.Kind+"/"+by.Name)
+		}
+		secret := res.Secret
+		if res.SecretNamespace != "" {
+			secret = res.SecretNamespace + "/" + secret
+		}
+		status := res.Status
+		if res.Baseline {
+			status += " (baseline)"
+		}
+		if res.Error != "" {
+			status += ": " + res.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", res.ID, res.Cluster, res.Namespace, strings.Join(referrers, ","), secret, notAfter, days, status)
+	}
+	err := tw.Flush()
+	if err != nil || !r.Truncated {
+		return err
+	}
+
+	shown := 0
+	for _, res := range r.Results {
+		if res.NotAfter != nil {
+			shown++
+		}
+	}
+	_, err = fmt.Fprintf(w, "Showing the %d soonest expiring of %d certificates\n", shown, r.TotalDated)
+	return err
+}
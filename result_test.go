@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ArnauSB/check-secrets/certs"
+)
+
+func datedResult(secret string, days int) result {
+	notAfter := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, days)
+	return result{Secret: secret, NotAfter: &notAfter, DaysRemaining: &days, Status: statusOK}
+}
+
+func TestSelectTop(t *testing.T) {
+	undated := result{Secret: "missing", Status: statusError}
+
+	tests := []struct {
+		name      string
+		results   []result
+		n         int
+		want      []string
+		wantTotal int
+	}{
+		{
+			name:      "soonest first",
+			results:   []result{datedResult("c", 30), datedResult("a", 1), datedResult("b", 10)},
+			n:         2,
+			want:      []string{"a", "b"},
+			wantTotal: 3,
+		},
+		{
+			name:      "ties with the last shown",
+			results:   []result{datedResult("a", 1), datedResult("b", 10), datedResult("c", 10), datedResult("d", 11)},
+			n:         2,
+			want:      []string{"a", "b", "c"},
+			wantTotal: 4,
+		},
+		{
+			name:      "undated results kept first",
+			results:   []result{datedResult("b", 10), undated, datedResult("a", 1)},
+			n:         1,
+			want:      []string{"missing", "a"},
+			wantTotal: 2,
+		},
+		{
+			name:      "fewer than n",
+			results:   []result{datedResult("b", 10), datedResult("a", 1)},
+			n:         5,
+			want:      []string{"a", "b"},
+			wantTotal: 2,
+		},
+		{
+			name:    "only undated",
+			results: []result{undated},
+			n:       1,
+			want:    []string{"missing"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total := selectTop(tt.results, tt.n)
+			var secrets []string
+			for _, r := range got {
+				secrets = append(secrets, r.Secret)
+			}
+			if strings.Join(secrets, ",") != strings.Join(tt.want, ",") || total != tt.wantTotal {
+				t.Errorf("selectTop() = %v, %d, want %v, %d", secrets, total, tt.want, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	defer func(days int) { *critDays = days }(*critDays)
+
+	with := func(r result, f func(*result)) result {
+		f(&r)
+		return r
+	}
+	status := func(s string) result { return with(datedResult("a", 60), func(r *result) { r.Status = s }) }
+	expired := status(statusExpired)
+
+	tests := []struct {
+		name     string
+		results  []result
+		critDays int
+		want     int
+	}{
+		{name: "no results", want: 0},
+		{name: "all ok", results: []result{status(statusOK), status(statusOK)}, want: 0},
+		{name: "warning", results: []result{status(statusOK), status(statusWarning)}, want: exitWarning},
+		{name: "unknown", results: []result{status(statusUnknown)}, want: exitWarning},
+		{name: "expired", results: []result{status(statusWarning), expired}, want: exitCritical},
+		{name: "error", results: []result{{Status: statusError}}, want: exitCritical},
+		{name: "silenced", results: []result{with(expired, func(r *result) { r.Silenced = "ticket-1" }), status(statusWarning)}, want: exitWarning},
+		{name: "baseline", results: []result{with(expired, func(r *result) { r.Baseline = true })}, want: 0},
+		{name: "within crit days", results: []result{with(datedResult("a", 5), func(r *result) { r.Status = statusWarning })}, critDays: 7, want: exitCritical},
+		{name: "at crit days", results: []result{with(datedResult("a", 7), func(r *result) { r.Status = statusWarning })}, critDays: 7, want: exitWarning},
+		{name: "silenced within crit days", results: []result{with(datedResult("a", 5), func(r *result) { r.Silenced = "ticket-1" })}, critDays: 7, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*critDays = tt.critDays
+			if got := exitCode(tt.results); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetFinding(t *testing.T) {
+	tests := []struct {
+		name       string
+		finding    certs.Finding
+		wantStatus string
+		wantCode   string
+		wantError  string
+	}{
+		{name: "ok", finding: certs.Finding{Status: statusOK}, wantStatus: statusOK, wantCode: findingCertOK},
+		{name: "expiring", finding: certs.Finding{Status: statusWarning}, wantStatus: statusWarning, wantCode: findingCertExpiring},
+		{name: "expired", finding: certs.Finding{Status: statusExpired}, wantStatus: statusExpired, wantCode: findingCertExpired},
+		{name: "expired with a bad key", finding: certs.Finding{Status: statusExpired, KeyError: "mismatch"}, wantStatus: statusExpired, wantCode: findingCertExpired},
+		{name: "key mismatch", finding: certs.Finding{Status: statusWarning, KeyError: "mismatch", ChainError: "unknown authority"}, wantStatus: statusError, wantCode: findingKeyMismatch, wantError: "mismatch"},
+		{name: "chain invalid", finding: certs.Finding{Status: statusOK, ChainError: "unknown authority", UncoveredHosts: []string{"a"}}, wantStatus: statusError, wantCode: findingChainInvalid, wantError: "unknown authority"},
+		{name: "host mismatch", finding: certs.Finding{Status: statusOK, UncoveredHosts: []string{"a.example.com", "b.example.com"}}, wantStatus: statusError, wantCode: findingHostMismatch, wantError: "hosts not covered by the certificate: a.example.com, b.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.finding.NotAfter = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+			tt.finding.DaysRemaining = 3
+			var r result
+			r.setFinding(tt.finding)
+			if r.Status != tt.wantStatus || r.Code != tt.wantCode || r.Error != tt.wantError {
+				t.Errorf("setFinding() = %s, %s, %q, want %s, %s, %q", r.Status, r.Code, r.Error, tt.wantStatus, tt.wantCode, tt.wantError)
+			}
+			if !r.NotAfter.Equal(tt.finding.NotAfter) || *r.DaysRemaining != 3 {
+				t.Errorf("setFinding() dates = %s, %d", r.NotAfter, *r.DaysRemaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	revisionLabel   = "istio.io/rev"
+	defaultRevision = "default"
+)
+
+// Revision -> namespaces of the gateway workloads running it
+func gatewayRevisions(kclient *kubernetes.Clientset, gw unstructured.Unstructured) (map[string][]string, error) {
+	pods, err := getGatewayPods(kclient, gw)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]map[string]bool{}
+	for _, pod := range pods {
+		rev := pod.Labels[revisionLabel]
+		if rev == "" {
+			rev = defaultRevision
+		}
+		if seen[rev] == nil {
+			seen[rev] = map[string]bool{}
+		}
+		seen[rev][pod.Namespace] = true
+	}
+
+	revisions := map[string][]string{}
+	for rev, namespaces := range seen {
+		for ns := range namespaces {
+			revisions[rev] = append(revisions[rev], ns)
+		}
+		sort.Strings(revisions[rev])
+	}
+
+	return revisions, nil
+}
+
+func sortedRevisions(revisions map[string][]string) []string {
+	var names []string
+	for rev := range revisions {
+		names = append(names, rev)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func gatewayCredentialNames(gw unstructured.Unstructured) []string {
+	var names []string
+	servers, _, _ := unstructured.NestedSlice(gw.Object, "spec", "servers")
+	for _, serverObj := range servers {
+		server, ok := serverObj.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		mode, _, _ := unstructured.NestedString(server, "tls", "mode")
+		credentialName, _, _ := unstructured.NestedString(server, "tls", "credentialName")
+		if mode != "PASSTHROUGH" && credentialName != "" {
+			names = append(names, credentialName)
+		}
+	}
+
+	return names
+}
+
+func checkRevisionSecrets(w io.Writer, lookup *secretLookup, gw unstructured.Unstructured, revisions map[string][]string, only string) {
+	// Gateway workloads read credentialName secrets from their own namespace
+	for _, rev := range sortedRevisions(revisions) {
+		if only != "" && rev != only {
+			continue
+		}
+
+		for _, ns := range revisions[rev] {
+			for _, name := range gatewayCredentialNames(gw) {
+				_, err := lookup.get(ns, name)
+				if apierrors.IsNotFound(err) {
+					fmt.Fprintf(w, "warning: secret %s for gateway %s in namespace %s is missing in namespace %s used by revision %s\n", name, gw.GetName(), gw.GetNamespace(), ns, rev)
+				} else if err != nil {
+					fmt.Fprintf(w, "error getting secret %s in namespace %s for revision %s: %v\n", name, ns, rev, err)
+				}
+			}
+		}
+	}
+}
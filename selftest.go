@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+const selfTestNamespace = "self-test"
+
+type selfTestCertificate struct {
+	notBefore, notAfter time.Time
+	dnsNames            []string
+	// Issued by an intermediate expiring at this time instead of self-signed
+	intermediateNotAfter time.Time
+	// Intermediate in ca.crt instead of tls.crt
+	intermediateAsCA bool
+	// ca.crt from an unrelated CA
+	foreignCA bool
+	// tls.key not matching the certificate
+	wrongKey bool
+}
+
+// One gateway server per scenario, each pointing at its own secret
+type selfTestCase struct {
+	name   string
+	secret string
+	hosts  []string
+
+	// Nil leaves the secret missing
+	build func() (*corev1.Secret, error)
+
+	wantCode string
+	// Extra check on the analysis of the secret alone
+	check func(certs.Finding) error
+}
+
+func selfTestCA(name string, notBefore, notAfter time.Time) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+
+	return ca, key, err
+}
+
+func selfTestData(c selfTestCertificate) (map[string][]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "check-secrets self-test"},
+		NotBefore:    c.notBefore,
+		NotAfter:     c.notAfter,
+		DNSNames:     c.dnsNames,
+	}
+	encode := func(der []byte) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	data := map[string][]byte{}
+
+	if c.intermediateNotAfter.IsZero() {
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			return nil, err
+		}
+		data["tls.crt"] = encode(der)
+	} else {
+		ca, caKey, err := selfTestCA("check-secrets self-test intermediate", c.notBefore, c.intermediateNotAfter)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			return nil, err
+		}
+		data["tls.crt"] = encode(der)
+		if c.intermediateAsCA {
+			data["ca.crt"] = encode(ca.Raw)
+		} else {
+			data["tls.crt"] = append(data["tls.crt"], encode(ca.Raw)...)
+		}
+	}
+
+	if c.foreignCA {
+		ca, _, err := selfTestCA("check-secrets self-test foreign CA", c.notBefore, c.notAfter)
+		if err != nil {
+			return nil, err
+		}
+		data["ca.crt"] = encode(ca.Raw)
+	}
+
+	if c.wrongKey {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data["tls.key"] = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	return data, nil
+}
+
+func selfTestSecret(name string, c selfTestCertificate) func() (*corev1.Secret, error) {
+	return func() (*corev1.Secret, error) {
+		data, err := selfTestData(c)
+		if err != nil {
+			return nil, err
+		}
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: selfTestNamespace, CreationTimestamp: metav1.Now()},
+			Type:       corev1.SecretTypeTLS,
+			Data:       data,
+		}, nil
+	}
+}
+
+func selfTestCases(now time.Time) []selfTestCase {
+	day := 24 * time.Hour
+	return []selfTestCase{
+		{
+			name: "valid certificate", secret: "valid", hosts: []string{"valid.example.com"},
+			build:    selfTestSecret("valid", selfTestCertificate{notBefore: now.Add(-day), notAfter: now.Add(90 * day), dnsNames: []string{"valid.example.com"}}),
+			wantCode: findingCertOK,
+		},
+		{
+			name: "expiring within --warn-days", secret: "expiring", hosts: []string{"expiring.example.com"},
+			build:    selfTestSecret("expiring", selfTestCertificate{notBefore: now.Add(-day), notAfter: now.Add(10 * day), dnsNames: []string{"expiring.example.com"}}),
+			wantCode: findingCertExpiring,
+		},
+		{
+			name: "expired certificate", secret: "expired", hosts: []string{"expired.example.com"},
+			build:    selfTestSecret("expired", selfTestCertificate{notBefore: now.Add(-90 * day), notAfter: now.Add(-day), dnsNames: []string{"expired.example.com"}}),
+			wantCode: findingCertExpired,
+		},
+		{
+			name: "missing secret", secret: "missing", hosts: []string{"missing.example.com"},
+			wantCode: findingSecretMissing,
+		},
+		{
+			name: "DER encoded certificate", secret: "der", hosts: []string{"der.example.com"},
+			build: func() (*corev1.Secret, error) {
+				secret, err := selfTestSecret("der", selfTestCertificate{notBefore: now.Add(-day), notAfter: now.Add(90 * day)})()
+				if err != nil {
+					return nil, err
+				}
+				block, _ := pem.Decode(secret.Data["tls.crt"])
+				secret.Data["tls.crt"] = block.Bytes
+				return secret, nil
+			},
+			wantCode: findingCertInvalid,
+		},
+		{
+			name: "intermediate expiring before the leaf", secret: "chain", hosts: []string{"chain.example.com"},
+			build:    selfTestSecret("chain", selfTestCertificate{notBefore: now.Add(-day), notAfter: now.Add(90 * day), intermediateNotAfter: now.Add(60 * day), dnsNames: []string{"chain.example.com"}}),
+			wantCode: findingCertOK,
+			check: func(f certs.Finding) error {
+				if len(f.EarlyIntermediates) != 1 {
+					return fmt.Errorf("expected 1 intermediate expiring before the leaf, got %d", len(f.EarlyIntermediates))
+				}
+				return nil
+			},
+		},
+		{
+			name: "SAN not covering the host", secret: "san-miss", hosts: []string{"shop.example.com"},
+			build:    selfTestSecret("san-miss", selfTestCertificate{notBefore: now.Add(-day), notAfter: now.Add(90 * day), dnsNames: []string{"other.example.com"}}),
+			wantCode: findingHostMismatch,
+			check: func(f certs.Finding) error {
+				if len(f.UncoveredHosts) != 1 || f.UncoveredHosts[0] != "shop.example.com" {
+					return fmt.Errorf("expected shop.example.com uncovered, got %v", f.UncoveredHosts)
+				}
+				return nil
+			},
+		},
+		{
+			name: "chain verifying against ca.crt", secret: "ca-ok", hosts: []string{"ca-ok.example.com"},
+			build:    selfTestSecret("ca-ok", selfTestCertificate{notBefore: now.Add(-day), notAfter: now.Add(90 * day), intermediateNotAfter: now.Add(365 * day), intermediateAsCA: true, dnsNames: []string{"ca-ok.example.com"}}),
+			wantCode: findingCertOK,
+		},
+		{
+			name: "chain not verifying against ca.crt", secret: "ca-foreign", hosts: []string{"ca-foreign.example.com"},
+			build:    selfTestSecret("ca-foreign", selfTestCertificate{notBefore: now.Add(-day), notAfter: now.Add(90 * day), foreignCA: true, dnsNames: []string{"ca-foreign.example.com"}}),
+			wantCode: findingChainInvalid,
+		},
+		{
+			name: "private key not matching", secret: "wrong-key", hosts: []string{"wrong-key.example.com"},
+			build:    selfTestSecret("wrong-key", selfTestCertificate{notBefore: now.Add(-day), notAfter: now.Add(90 * day), wrongKey: true, dnsNames: []string{"wrong-key.example.com"}}),
+			wantCode: findingKeyMismatch,
+		},
+		{
+			name: "stale certificate installed", secret: "stale", hosts: []string{"stale.example.com"},
+			build:    selfTestSecret("stale", selfTestCertificate{notBefore: now.Add(-80 * day), notAfter: now.Add(20 * day), dnsNames: []string{"stale.example.com"}}),
+			wantCode: findingCertExpiring,
+			check: func(f certs.Finding) error {
+				if !f.StaleInstall {
+					return fmt.Errorf("expected a stale install")
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// Just enough of the API server for scanNamespace: one Istio gateway and its secrets
+func selfTestServer(cases []selfTestCase, secrets map[string]*corev1.Secret) *httptest.Server {
+	var servers []interface{}
+	for i, tc := range cases {
+		servers = append(servers, map[string]interface{}{
+			"port":  map[string]interface{}{"number": int64(8443 + i), "name": "https-" + tc.secret, "protocol": "HTTPS"},
+			"hosts": tc.hosts,
+			"tls":   map[string]interface{}{"mode": "SIMPLE", "credentialName": tc.secret},
+		})
+	}
+	gateway := map[string]interface{}{
+		"apiVersion": gatewayResource.GroupVersion().String(),
+		"kind":       "Gateway",
+		"metadata":   map[string]interface{}{"name": "self-test", "namespace": selfTestNamespace},
+		"spec":       map[string]interface{}{"selector": map[string]interface{}{"istio": "ingressgateway"}, "servers": servers},
+	}
+
+	write := func(w http.ResponseWriter, code int, obj interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(obj)
+	}
+	notFound := func(w http.ResponseWriter, name string) {
+		write(w, http.StatusNotFound, metav1.Status{
+			TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+			Status:   metav1.StatusFailure, Code: http.StatusNotFound, Reason: metav1.StatusReasonNotFound,
+			Message: name + " not found",
+		})
+	}
+
+	prefix := "/api/v1/namespaces/" + selfTestNamespace
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch p := r.URL.Path; {
+		case p == "/apis/"+gatewayResource.GroupVersion().String()+"/namespaces/"+selfTestNamespace+"/gateways":
+			write(w, http.StatusOK, map[string]interface{}{"apiVersion": gatewayResource.GroupVersion().String(), "kind": "GatewayList", "metadata": map[string]interface{}{}, "items": []interface{}{gateway}})
+		case strings.HasPrefix(p, prefix+"/secrets/"):
+			name := strings.TrimPrefix(p, prefix+"/secrets/")
+			secret, ok := secrets[name]
+			if !ok {
+				notFound(w, name)
+				return
+			}
+			secret.TypeMeta = metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"}
+			write(w, http.StatusOK, secret)
+		case p == prefix:
+			write(w, http.StatusOK, corev1.Namespace{TypeMeta: metav1.TypeMeta{Kind: "Namespace", APIVersion: "v1"}, ObjectMeta: metav1.ObjectMeta{Name: selfTestNamespace}})
+		default:
+			notFound(w, p)
+		}
+	}))
+}
+
+// Runs the scan pipeline against built-in fixtures, for builds that can't run the Go tests
+func selfTest() int {
+	// Fixtures assume these, whatever was passed on the command line
+	*warnDays, *critDays, *staleInstallFraction = 30, 0, 0.5
+	*managedBy, *revision = "", ""
+	*dedupeBySecret, *noRecheck, *checkRevisions, *checkPodRestarts = false, false, false, false
+	*sampleWorkloads, *top = 0, 0
+	clock = time.Now
+
+	now := time.Now()
+	cases := selfTestCases(now)
+	secrets := map[string]*corev1.Secret{}
+	failed := 0
+	fail := func(scenario string, err error) {
+		failed++
+		fmt.Printf("FAIL %s: %v\n", scenario, err)
+	}
+
+	for _, tc := range cases {
+		if tc.build == nil {
+			continue
+		}
+		secret, err := tc.build()
+		if err != nil {
+			fail(tc.name, fmt.Errorf("unable to build fixture: %v", err))
+			continue
+		}
+		secrets[tc.secret] = secret
+	}
+
+	srv := selfTestServer(cases, secrets)
+	defer srv.Close()
+	kclient, dclient, err := newClients(&rest.Config{Host: srv.URL})
+	if err != nil {
+		fail("client setup", err)
+		return 1
+	}
+
+	// Scan output is checked, not shown
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		fail("scan", err)
+		return 1
+	}
+	defer devNull.Close()
+	stdout := os.Stdout
+	os.Stdout = devNull
+	scan := &scanContext{cluster: "self-test", buffered: true, drift: newDriftTracker(nil), sources: map[string]bool{sourceIstio: true}}
+	rechecks := scan.scanNamespace(kclient, dclient, selfTestNamespace, newAbsentGroups(scan.cluster, nil))
+	scan.recheckMissingSecrets(kclient, rechecks, 0)
+	os.Stdout = stdout
+
+	bySecret := map[string]result{}
+	for _, r := range scan.results {
+		bySecret[r.Secret] = r
+	}
+	for _, tc := range cases {
+		r, ok := bySecret[tc.secret]
+		switch {
+		case !ok:
+			fail(tc.name, fmt.Errorf("no finding for secret %s", tc.secret))
+			continue
+		case r.Code != tc.wantCode:
+			fail(tc.name, fmt.Errorf("expected %s, got %s (%s)", tc.wantCode, r.Code, r.Error))
+			continue
+		case r.ID == "" || r.ID != findingID(scan.cluster, r):
+			fail(tc.name, fmt.Errorf("unstable finding ID %q", r.ID))
+			continue
+		}
+
+		if tc.check != nil {
+			f, err := certs.EvaluateHosts(*secrets[tc.secret], tc.hosts, certs.EvalOptions{WarnDays: *warnDays, StaleInstallFraction: *staleInstallFraction})
+			if err == nil {
+				err = tc.check(f)
+			}
+			if err != nil {
+				fail(tc.name, err)
+				continue
+			}
+		}
+		fmt.Printf("PASS %s\n", tc.name)
+	}
+
+	// Every output format must render the same findings
+	var buf bytes.Buffer
+	var decoded report
+	err = renderJSON(&buf, newReport(scan, scan.results))
+	if err == nil {
+		err = json.Unmarshal(buf.Bytes(), &decoded)
+	}
+	if err == nil && len(decoded.Results) != len(scan.results) {
+		err = fmt.Errorf("expected %d results, got %d", len(scan.results), len(decoded.Results))
+	}
+	if err != nil {
+		fail("json output", err)
+	} else {
+		fmt.Println("PASS json output")
+	}
+
+	buf.Reset()
+	decoded = report{}
+	err = renderYAML(&buf, newReport(scan, scan.results))
+	if err == nil {
+		err = yaml.Unmarshal(buf.Bytes(), &decoded)
+	}
+	if err == nil && len(decoded.Results) != len(scan.results) {
+		err = fmt.Errorf("expected %d results, got %d", len(scan.results), len(decoded.Results))
+	}
+	if err != nil {
+		fail("yaml output", err)
+	} else {
+		fmt.Println("PASS yaml output")
+	}
+
+	buf.Reset()
+	err = renderTable(&buf, newReport(scan, scan.results))
+	if lines := strings.Count(buf.String(), "\n"); err == nil && lines != len(scan.results)+1 {
+		err = fmt.Errorf("expected %d lines, got %d", len(scan.results)+1, lines)
+	}
+	if err != nil {
+		fail("table output", err)
+	} else {
+		fmt.Println("PASS table output")
+	}
+
+	for _, r := range scan.results {
+		if r.text == "" {
+			fail("text output", fmt.Errorf("no line for secret %s", r.Secret))
+			break
+		}
+	}
+	if failed == 0 {
+		fmt.Println("PASS text output")
+		fmt.Printf("Self-test passed, %d scenarios\n", len(cases))
+		return 0
+	}
+
+	fmt.Printf("Self-test failed, %d checks failed\n", failed)
+	return 1
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// Latest completed scan, as served on /metrics
+type metricsState struct {
+	mu           sync.Mutex
+	scan         *scanContext
+	lastScan     time.Time
+	lastDuration time.Duration
+	scans        int
+	failures     int
+	apiRequests  int64
+
+	// Scan errors by code, over every scan since start
+	errors map[string]int
+}
+
+// Rescans every interval, keeping the previous results when a scan fails
+func serveMetrics(kclient *kubernetes.Clientset, addr string, tlsOpts listenerTLS, interval time.Duration, run func() (*scanContext, error)) error {
+	state := &metricsState{errors: map[string]int{}}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			start := time.Now()
+			scan, err := run()
+			state.record(scan, err, start)
+			<-ticker.C
+		}
+	}()
+
+	fmt.Printf("Serving metrics on %s, scanning every %s\n", addr, interval)
+
+	return listen(kclient, addr, tlsOpts, map[string]http.Handler{"/metrics": state})
+}
+
+func (m *metricsState) record(scan *scanContext, err error, start time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.scans++
+	m.lastDuration = time.Since(start)
+	m.apiRequests = apiBudget.used.Load()
+	if scan != nil {
+		for _, e := range scan.errors {
+			m.errors[e.Code]++
+		}
+	}
+	if err != nil {
+		m.failures++
+		fmt.Fprintf(os.Stderr, "error scanning, keeping the previous results: %v\n", err)
+		return
+	}
+
+	m.scan, m.lastScan = scan, time.Now()
+	fmt.Fprintf(os.Stderr, "Scan finished in %s: %d results, %d errors\n", m.lastDuration.Round(time.Millisecond), len(scan.results), len(scan.errors))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Label pairs as name, value, ...
+func metricLabels(pairs ...string) string {
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", pairs[i], labelEscaper.Replace(pairs[i+1])))
+	}
+
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// Rendered under the lock and written after, so a slow scraper never holds up the scan loop
+func (m *metricsState) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	m.render(&b)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = b.WriteTo(w)
+}
+
+// Prometheus text exposition format
+func (m *metricsState) render(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	metric("check_secrets_scans_total", "counter", "Scans run since start.")
+	fmt.Fprintf(w, "check_secrets_scans_total %d\n", m.scans)
+	metric("check_secrets_scan_failures_total", "counter", "Scans that failed, leaving the previous results in place.")
+	fmt.Fprintf(w, "check_secrets_scan_failures_total %d\n", m.failures)
+	metric("check_secrets_scan_errors_total", "counter", "Errors met while scanning, by code.")
+	for _, code := range sortedKeys(m.errors) {
+		fmt.Fprintf(w, "check_secrets_scan_errors_total%s %d\n", metricLabels("code", code), m.errors[code])
+	}
+	metric("check_secrets_last_scan_duration_seconds", "gauge", "Duration of the last scan.")
+	fmt.Fprintf(w, "check_secrets_last_scan_duration_seconds %g\n", m.lastDuration.Seconds())
+	metric("check_secrets_last_scan_api_requests", "gauge", "API requests made by the last scan.")
+	fmt.Fprintf(w, "check_secrets_last_scan_api_requests %d\n", m.apiRequests)
+
+	if m.scan == nil {
+		return
+	}
+	metric("check_secrets_last_scan_timestamp_seconds", "gauge", "Time the last successful scan finished.")
+	fmt.Fprintf(w, "check_secrets_last_scan_timestamp_seconds %d\n", m.lastScan.Unix())
+
+	// One series per referencing object and host, the earliest expiry when results share one
+	metric("istio_gateway_cert_expiry_timestamp_seconds", "gauge", "Certificate expiry as a Unix timestamp.")
+	expiries := map[string]int64{}
+	findings := map[string]int{}
+	for _, res := range m.scan.results {
+		findings[metricLabels("cluster", res.Cluster, "status", res.Status, "code", res.Code)]++
+		if res.NotAfter == nil {
+			continue
+		}
+
+		secret := res.Secret
+		if res.SecretNamespace != "" {
+			secret = res.SecretNamespace + "/" + secret
+		}
+		hosts := res.Hosts
+		if len(hosts) == 0 {
+			hosts = []string{""}
+		}
+		for _, by := range res.ReferencedBy {
+			for _, host := range hosts {
+				labels := metricLabels("cluster", res.Cluster, "namespace", res.Namespace, "kind", by.Kind, "gateway", by.Name, "secret", secret, "host", host)
+				if expiry, ok := expiries[labels]; !ok || res.NotAfter.Unix() < expiry {
+					expiries[labels] = res.NotAfter.Unix()
+				}
+			}
+		}
+	}
+	for _, labels := range sortedKeys(expiries) {
+		fmt.Fprintf(w, "istio_gateway_cert_expiry_timestamp_seconds%s %d\n", labels, expiries[labels])
+	}
+
+	metric("check_secrets_findings", "gauge", "Results of the last scan, by status and code.")
+	for _, labels := range sortedKeys(findings) {
+		fmt.Fprintf(w, "check_secrets_findings%s %d\n", labels, findings[labels])
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Blocks every write until released, like a scraper that stopped reading
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	close(w.writing)
+	<-w.release
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestMetricsStalledScraper(t *testing.T) {
+	state := &metricsState{errors: map[string]int{}}
+	state.record(&scanContext{results: []result{{Namespace: "apps", Status: statusOK, Code: findingCertOK}}}, nil, time.Now())
+
+	w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), writing: make(chan struct{}), release: make(chan struct{})}
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		state.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	}()
+	<-w.writing
+
+	// The scan loop publishes while the scrape is stuck writing
+	recorded := make(chan struct{})
+	go func() {
+		defer close(recorded)
+		state.record(&scanContext{}, nil, time.Now())
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("record() blocked behind a stalled scrape")
+	}
+
+	close(w.release)
+	<-served
+	body := w.Body.String()
+	if !strings.Contains(body, "check_secrets_scans_total 1\n") || !strings.Contains(body, `check_secrets_findings{cluster="",status="ok",code="CERT_OK"} 1`) {
+		t.Errorf("metrics = %s, want the state of the first scan", body)
+	}
+}
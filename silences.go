@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+const silencesKey = "silences.yaml"
+
+type silence struct {
+	ID        string    `json:"id,omitempty"`
+	Cluster   string    `json:"cluster,omitempty"`
+	Namespace string    `json:"namespace"`
+	Secret    string    `json:"secret"`
+	Expires   time.Time `json:"expires"`
+	Comment   string    `json:"comment"`
+}
+
+type silenceFile struct {
+	Silences []silence `json:"silences"`
+}
+
+func loadSilences(kclient *kubernetes.Clientset, file, configMapRef string) ([]silence, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	switch {
+	case file != "":
+		data, err = os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read silences file: %v", err)
+		}
+	case configMapRef != "":
+		ns, name, ok := strings.Cut(configMapRef, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid configmap %q, expected <namespace>/<name>", configMapRef)
+		}
+
+		cm, err := kclient.CoreV1().ConfigMaps(ns).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error getting configmap %s in namespace %s: %v", name, ns, err)
+		}
+		data = []byte(cm.Data[silencesKey])
+	default:
+		return nil, nil
+	}
+
+	var parsed silenceFile
+	err = yaml.Unmarshal(data, &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse silences: %v", err)
+	}
+
+	var active []silence
+	for i, s := range parsed.Silences {
+		if (s.ID == "" && (s.Namespace == "" || s.Secret == "")) || s.Expires.IsZero() || s.Comment == "" {
+			return nil, fmt.Errorf("silence %d must set namespace and secret or id, expires and comment", i)
+		}
+
+		// Stale entries are dropped loudly so they can be cleaned up
+		if clock().After(s.Expires) {
+			fmt.Fprintf(os.Stderr, "warning: silence for %s expired at %s (%s)\n", s.target(), s.Expires.Format(time.RFC3339), s.Comment)
+			continue
+		}
+		active = append(active, s)
+	}
+
+	return active, nil
+}
+
+func findSilence(silences []silence, cluster, namespace, secret, id string) *silence {
+	for i, s := range silences {
+		// A finding ID pins one exact finding
+		if s.ID != "" {
+			if s.ID == id {
+				return &silences[i]
+			}
+			continue
+		}
+		if s.Cluster != "" && s.Cluster != cluster {
+			continue
+		}
+
+		// Namespace and secret accept glob patterns
+		nsMatch, _ := path.Match(s.Namespace, namespace)
+		secretMatch, _ := path.Match(s.Secret, secret)
+		if nsMatch && secretMatch {
+			return &silences[i]
+		}
+	}
+
+	return nil
+}
+
+func (s *silence) target() string {
+	if s.ID != "" {
+		return s.ID
+	}
+
+	return s.Namespace + "/" + s.Secret
+}
+
+func (s *silence) String() string {
+	return fmt.Sprintf("silenced: %s until %s", s.Comment, s.Expires.Format(time.RFC3339))
+}
+
+func listSilences(silences []silence) error {
+	if len(silences) == 0 {
+		fmt.Println("No active silences")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tNAMESPACE\tSECRET\tID\tEXPIRES\tCOMMENT")
+	for _, s := range silences {
+		cluster := s.Cluster
+		if cluster == "" {
+			cluster = "*"
+		}
+		namespace, secret, id := s.Namespace, s.Secret, s.ID
+		if id == "" {
+			id = "-"
+		} else {
+			namespace, secret = "-", "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", cluster, namespace, secret, id, s.Expires.Format(time.RFC3339), s.Comment)
+	}
+
+	return w.Flush()
+}
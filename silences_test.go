@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindSilence(t *testing.T) {
+	silences := []silence{
+		{ID: "v1-80537528d35a6be3", Comment: "pinned"},
+		{Cluster: "prod", Namespace: "payments", Secret: "api-cert", Comment: "prod only"},
+		{Namespace: "team-*", Secret: "*-legacy", Comment: "glob"},
+		{Namespace: "apps", Secret: "www-cert", Comment: "exact"},
+	}
+
+	tests := []struct {
+		name      string
+		cluster   string
+		namespace string
+		secret    string
+		id        string
+		want      string
+	}{
+		{name: "finding id", namespace: "other", secret: "other", id: "v1-80537528d35a6be3", want: "pinned"},
+		{name: "other finding id", namespace: "other", secret: "other", id: "v1-bac19a6ccd5c1887"},
+		{name: "matching cluster", cluster: "prod", namespace: "payments", secret: "api-cert", want: "prod only"},
+		{name: "other cluster", cluster: "staging", namespace: "payments", secret: "api-cert"},
+		{name: "single cluster", namespace: "payments", secret: "api-cert"},
+		{name: "glob", namespace: "team-a", secret: "shop-legacy", want: "glob"},
+		{name: "glob not matching", namespace: "team-a", secret: "shop-cert"},
+		{name: "glob any cluster", cluster: "staging", namespace: "team-b", secret: "api-legacy", want: "glob"},
+		{name: "exact", namespace: "apps", secret: "www-cert", want: "exact"},
+		{name: "exact other secret", namespace: "apps", secret: "www-cert-2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := findSilence(silences, tt.cluster, tt.namespace, tt.secret, tt.id)
+			switch {
+			case got == nil && tt.want != "":
+				t.Errorf("findSilence() = nil, want %q", tt.want)
+			case got != nil && got.Comment != tt.want:
+				t.Errorf("findSilence() = %q, want %q", got.Comment, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSilences(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock = func() time.Time { return now }
+	defer func() { clock = time.Now }()
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "active and expired",
+			data: `silences:
+- {namespace: apps, secret: www-cert, expires: "2024-07-01T00:00:00Z", comment: active}
+- {namespace: apps, secret: old-cert, expires: "2024-05-01T00:00:00Z", comment: expired}
+- {id: v1-80537528d35a6be3, expires: "2024-06-02T00:00:00Z", comment: pinned}
+`,
+			want: []string{"active", "pinned"},
+		},
+		{name: "empty file"},
+		{name: "missing secret", data: "silences:\n- {namespace: apps, expires: \"2024-07-01T00:00:00Z\", comment: x}\n", wantErr: "silence 0 must set"},
+		{name: "missing expires", data: "silences:\n- {namespace: apps, secret: a, comment: x}\n", wantErr: "silence 0 must set"},
+		{name: "missing comment", data: "silences:\n- {id: v1-80537528d35a6be3, expires: \"2024-07-01T00:00:00Z\"}\n", wantErr: "silence 0 must set"},
+		{name: "not yaml", data: "silences: [", wantErr: "unable to parse silences"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "silences.yaml")
+			if err := os.WriteFile(file, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			silences, err := loadSilences(nil, file, "")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadSilences() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadSilences() error = %v", err)
+			}
+			var comments []string
+			for _, s := range silences {
+				comments = append(comments, s.Comment)
+			}
+			if strings.Join(comments, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("loadSilences() = %v, want %v", comments, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	kubeGatewayResource = schema.GroupVersionResource{
+		Group:    "gateway.networking.k8s.io",
+		Version:  "v1",
+		Resource: "gateways",
+	}
+	referenceGrantResource = schema.GroupVersionResource{
+		Group:    "gateway.networking.k8s.io",
+		Version:  "v1beta1",
+		Resource: "referencegrants",
+	}
+)
+
+// Values accepted by --sources
+const (
+	sourceIstio      = "istio"
+	sourceGatewayAPI = "gateway-api"
+	sourceIngress    = "ingress"
+)
+
+// Kinds of objects referencing a secret, as shown in the report
+const (
+	kindIstioGateway = "IstioGateway"
+	kindGateway      = "Gateway"
+	kindIngress      = "Ingress"
+
+	// Dialed live by --check-eastwest, no secret behind it
+	kindEastWestGateway = "EastWestGateway"
+)
+
+func parseSources(value string) (map[string]bool, error) {
+	sources := map[string]bool{}
+	for _, source := range strings.Split(value, ",") {
+		switch source = strings.TrimSpace(source); source {
+		case sourceIstio, sourceGatewayAPI, sourceIngress:
+			sources[source] = true
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown source %q, expected %s, %s or %s", source, sourceIstio, sourceGatewayAPI, sourceIngress)
+		}
+	}
+
+	return sources, nil
+}
+
+// API group each source lists, disabled for the run when it isn't installed
+var sourceGroups = map[string]string{
+	sourceIstio:      gatewayResource.Group,
+	sourceGatewayAPI: kubeGatewayResource.Group,
+}
+
+type disabledSource struct {
+	Cluster string `json:"cluster,omitempty"`
+	Source  string `json:"source"`
+	Group   string `json:"group"`
+}
+
+// Sources disabled in one cluster, shared by its namespaces
+type absentGroups struct {
+	mu       sync.Mutex
+	cluster  string
+	disabled map[string]bool
+
+	// Disabled from the cache, not written back so the entry still expires
+	cached map[string]bool
+}
+
+func newAbsentGroups(cluster string, cached []string) *absentGroups {
+	a := &absentGroups{cluster: cluster, disabled: map[string]bool{}, cached: map[string]bool{}}
+	for source, group := range sourceGroups {
+		for _, absent := range cached {
+			if absent == group {
+				a.disable(source, " (cached)")
+				a.cached[source] = true
+			}
+		}
+	}
+
+	return a
+}
+
+// Reported once, namespaces already listing when it happens are not
+func (a *absentGroups) disable(source, note string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.disabled[source] {
+		return
+	}
+	a.disabled[source] = true
+	fmt.Fprintf(os.Stderr, "%s not installed in cluster %s%s, %s scanner disabled\n", sourceGroups[source], a.cluster, note, source)
+}
+
+func (a *absentGroups) isDisabled(source string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.disabled[source]
+}
+
+func (a *absentGroups) list() []disabledSource {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var list []disabledSource
+	for source := range a.disabled {
+		list = append(list, disabledSource{Cluster: a.cluster, Source: source, Group: sourceGroups[source]})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Source < list[j].Source })
+
+	return list
+}
+
+// Groups found missing by this run, for the cache
+func (a *absentGroups) detected() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var groups []string
+	for source := range a.disabled {
+		if !a.cached[source] {
+			groups = append(groups, sourceGroups[source])
+		}
+	}
+	sort.Strings(groups)
+
+	return groups
+}
+
+type referrer struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+func (r referrer) String() string {
+	switch r.Kind {
+	case kindGateway:
+		return "Gateway API gateway " + r.Name
+	case kindIngress:
+		return "ingress " + r.Name
+	case kindEastWestGateway:
+		return "east-west gateway " + r.Name
+	}
+
+	// Istio gateways keep the historical wording
+	return "gateway " + r.Name
+}
+
+// A secret as used by one referencing object
+type secretUse struct {
+	secret    corev1.Secret
+	by        referrer
+	ports     []int64
+	hosts     []string
+	refs      []string
+	revisions []string
+
+	// Referenced by an Istio MUTUAL or OPTIONAL_MUTUAL server
+	clientCA bool
+}
+
+type missingSecret struct {
+	namespace string
+	name      string
+	by        referrer
+
+	// As the secretUse would have, for the recheck
+	ports     []int64
+	hosts     []string
+	revisions []string
+	clientCA  bool
+}
+
+// Secrets fetched once for all the objects of one namespace
+type secretLookup struct {
+	kclient *kubernetes.Clientset
+	secrets map[string]*corev1.Secret
+
+	// Secrets only known from their metadata, their data was forbidden
+	restricted map[string]bool
+}
+
+func newSecretLookup(kclient *kubernetes.Clientset) *secretLookup {
+	return &secretLookup{kclient: kclient, secrets: map[string]*corev1.Secret{}, restricted: map[string]bool{}}
+}
+
+func (l *secretLookup) isRestricted(secret corev1.Secret) bool {
+	return l.restricted[secret.Namespace+"/"+secret.Name]
+}
+
+func (l *secretLookup) get(ns, name string) (*corev1.Secret, error) {
+	key := ns + "/" + name
+	if secret, ok := l.secrets[key]; ok {
+		return secret, nil
+	}
+
+	secret, err := l.kclient.CoreV1().Secrets(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsForbidden(err) {
+		// Still report the secret exists when only its metadata is readable
+		meta, metaErr := secretMetadata(l.kclient, ns, name)
+		if metaErr != nil {
+			debugf("Metadata fallback for secret %s in namespace %s failed: %v", name, ns, metaErr)
+			return nil, err
+		}
+		secret, err = &corev1.Secret{ObjectMeta: meta.ObjectMeta}, nil
+		l.restricted[key] = true
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.secrets[key] = secret
+
+	return secret, nil
+}
+
+const (
+	partialMetadataAccept     = "application/json;as=PartialObjectMetadata;g=meta.k8s.io;v=v1"
+	partialMetadataListAccept = "application/json;as=PartialObjectMetadataList;g=meta.k8s.io;v=v1"
+)
+
+// RBAC authorizes a metadata get like a full get, so a list allowed by name is tried too
+func secretMetadata(kclient *kubernetes.Clientset, ns, name string) (*metav1.PartialObjectMetadata, error) {
+	// The client's scheme doesn't know meta.k8s.io, so the body is decoded as plain JSON
+	var meta metav1.PartialObjectMetadata
+	data, err := kclient.CoreV1().RESTClient().Get().Namespace(ns).Resource("secrets").Name(name).
+		SetHeader("Accept", partialMetadataAccept).Do(context.TODO()).Raw()
+	if err == nil {
+		err = json.Unmarshal(data, &meta)
+		return &meta, err
+	}
+	if !apierrors.IsForbidden(err) {
+		return nil, err
+	}
+
+	var list metav1.PartialObjectMetadataList
+	data, err = kclient.CoreV1().RESTClient().Get().Namespace(ns).Resource("secrets").
+		Param("fieldSelector", "metadata.name="+name).
+		SetHeader("Accept", partialMetadataListAccept).Do(context.TODO()).Raw()
+	if err == nil {
+		err = json.Unmarshal(data, &list)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Items) == 0 {
+		return nil, fmt.Errorf("secret not found in metadata list")
+	}
+
+	return &list.Items[0], nil
+}
+
+func referenceGranted(dclient dynamic.Interface, fromNamespace, secretNamespace, secretName string) (bool, error) {
+	grants, err := dclient.Resource(referenceGrantResource).Namespace(secretNamespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	for _, grant := range grants.Items {
+		fromOK, toOK := false, false
+		from, _, _ := unstructured.NestedSlice(grant.Object, "spec", "from")
+		for _, obj := range from {
+			f, _ := obj.(map[string]interface{})
+			if f["group"] == kubeGatewayResource.Group && f["kind"] == kindGateway && f["namespace"] == fromNamespace {
+				fromOK = true
+			}
+		}
+		to, _, _ := unstructured.NestedSlice(grant.Object, "spec", "to")
+		for _, obj := range to {
+			t, _ := obj.(map[string]interface{})
+			name, _ := t["name"].(string)
+			if (t["group"] == nil || t["group"] == "") && t["kind"] == "Secret" && (name == "" || name == secretName) {
+				toOK = true
+			}
+		}
+		if fromOK && toOK {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func kubeGatewaySecrets(w io.Writer, lookup *secretLookup, dclient dynamic.Interface, gw unstructured.Unstructured) ([]secretUse, []missingSecret, error) {
+	var (
+		uses    []secretUse
+		missing []missingSecret
+	)
+	by := referrer{Kind: kindGateway, Name: gw.GetName()}
+
+	listeners, _, _ := unstructured.NestedSlice(gw.Object, "spec", "listeners")
+	for _, listenerObj := range listeners {
+		listener, ok := listenerObj.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// Terminate is the default mode, passthrough listeners hold no certificate
+		mode, _, _ := unstructured.NestedString(listener, "tls", "mode")
+		if mode == "Passthrough" {
+			continue
+		}
+
+		name, _, _ := unstructured.NestedString(listener, "name")
+		port, _, _ := unstructured.NestedInt64(listener, "port")
+		hostname, _, _ := unstructured.NestedString(listener, "hostname")
+		certRefs, _, _ := unstructured.NestedSlice(listener, "tls", "certificateRefs")
+		for _, refObj := range certRefs {
+			ref, ok := refObj.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			group, _ := ref["group"].(string)
+			kind, _ := ref["kind"].(string)
+			if group != "" || (kind != "" && kind != "Secret") {
+				debugf("Skipping %s/%s certificate reference in Gateway %s listener %s", group, kind, gw.GetName(), name)
+				continue
+			}
+
+			// References may point at another namespace when a ReferenceGrant allows it
+			secretName, _ := ref["name"].(string)
+			secretNamespace, _ := ref["namespace"].(string)
+			if secretNamespace == "" {
+				secretNamespace = gw.GetNamespace()
+			}
+			if secretNamespace != gw.GetNamespace() {
+				granted, err := referenceGranted(dclient, gw.GetNamespace(), secretNamespace, secretName)
+				if err != nil {
+					fmt.Fprintf(w, "warning: unable to verify ReferenceGrants for secret %s in namespace %s: %v\n", secretName, secretNamespace, err)
+				} else if !granted {
+					fmt.Fprintf(w, "warning: Gateway API gateway %s in namespace %s references secret %s in namespace %s without a ReferenceGrant\n", gw.GetName(), gw.GetNamespace(), secretName, secretNamespace)
+				}
+			}
+
+			secret, err := lookup.get(secretNamespace, secretName)
+			if apierrors.IsNotFound(err) {
+				m := missingSecret{namespace: secretNamespace, name: secretName, by: by, ports: []int64{port}}
+				if hostname != "" {
+					m.hosts = []string{hostname}
+				}
+				missing = append(missing, m)
+				continue
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("error getting secret %s in namespace %s: %w", secretName, secretNamespace, err)
+			}
+
+			use := secretUse{secret: *secret, by: by, ports: []int64{port}}
+			use.refs = append(use.refs, fmt.Sprintf("%s listener %s port %d", by, name, port))
+			if hostname != "" {
+				use.hosts = append(use.hosts, hostname)
+			}
+			uses = append(uses, use)
+		}
+	}
+
+	return uses, missing, nil
+}
+
+func ingressSecrets(lookup *secretLookup, ing networkingv1.Ingress) ([]secretUse, []missingSecret, error) {
+	var (
+		uses    []secretUse
+		missing []missingSecret
+	)
+	by := referrer{Kind: kindIngress, Name: ing.Name}
+
+	for _, tls := range ing.Spec.TLS {
+		// No secretName means the controller's default certificate
+		if tls.SecretName == "" {
+			continue
+		}
+
+		secret, err := lookup.get(ing.Namespace, tls.SecretName)
+		if apierrors.IsNotFound(err) {
+			missing = append(missing, missingSecret{namespace: ing.Namespace, name: tls.SecretName, by: by, hosts: tls.Hosts})
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting secret %s in namespace %s: %w", tls.SecretName, ing.Namespace, err)
+		}
+
+		uses = append(uses, secretUse{secret: *secret, by: by, hosts: tls.Hosts, refs: []string{by.String()}})
+	}
+
+	return uses, missing, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// How the fake API server answers for one secret
+const (
+	accessFull     = "full"
+	accessMetadata = "metadata"
+	accessList     = "list"
+	accessNone     = "none"
+)
+
+// Serves secrets the way RBAC would with only some verbs and names granted,
+// proxying everything else to next when set
+func restrictedSecretsServer(t *testing.T, access map[string]string, next *httptest.Server) (*httptest.Server, *int) {
+	created := metav1.NewTime(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	object := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: selfTestNamespace, CreationTimestamp: created, Annotations: map[string]string{"cert-manager.io/certificate-name": name}}
+	}
+	write := func(w http.ResponseWriter, code int, obj interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(obj)
+	}
+	forbidden := func(w http.ResponseWriter, name string) {
+		write(w, http.StatusForbidden, metav1.Status{
+			TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+			Status:   metav1.StatusFailure, Code: http.StatusForbidden, Reason: metav1.StatusReasonForbidden,
+			Message: `secrets "` + name + `" is forbidden`,
+		})
+	}
+
+	var proxy http.Handler = http.NotFoundHandler()
+	if next != nil {
+		target, err := url.Parse(next.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxy = httputil.NewSingleHostReverseProxy(target)
+	}
+
+	requests := 0
+	prefix := "/api/v1/namespaces/" + selfTestNamespace + "/secrets"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asMetadata := strings.Contains(r.Header.Get("Accept"), "as=PartialObjectMetadata")
+
+		// A list allowed by name, as RBAC does with a metadata.name field selector
+		if r.URL.Path == prefix {
+			name := strings.TrimPrefix(r.URL.Query().Get("fieldSelector"), "metadata.name=")
+			if a, ok := access[name]; ok {
+				requests++
+				if a != accessList || !asMetadata {
+					forbidden(w, name)
+					return
+				}
+				write(w, http.StatusOK, metav1.PartialObjectMetadataList{
+					TypeMeta: metav1.TypeMeta{Kind: "PartialObjectMetadataList", APIVersion: "meta.k8s.io/v1"},
+					Items:    []metav1.PartialObjectMetadata{{TypeMeta: metav1.TypeMeta{Kind: "PartialObjectMetadata", APIVersion: "meta.k8s.io/v1"}, ObjectMeta: object(name)}},
+				})
+				return
+			}
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, prefix+"/")
+		a, ok := access[name]
+		if !ok || !strings.HasPrefix(r.URL.Path, prefix+"/") {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+		requests++
+
+		switch {
+		case a == accessFull && !asMetadata:
+			write(w, http.StatusOK, corev1.Secret{TypeMeta: metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"}, ObjectMeta: object(name), Type: corev1.SecretTypeTLS})
+		case a == accessMetadata && asMetadata:
+			write(w, http.StatusOK, metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{Kind: "PartialObjectMetadata", APIVersion: "meta.k8s.io/v1"}, ObjectMeta: object(name)})
+		default:
+			forbidden(w, name)
+		}
+	}))
+
+	return srv, &requests
+}
+
+func TestSecretLookupMetadataFallback(t *testing.T) {
+	access := map[string]string{
+		"full":      accessFull,
+		"metadata":  accessMetadata,
+		"list":      accessList,
+		"forbidden": accessNone,
+	}
+	srv, requests := restrictedSecretsServer(t, access, nil)
+	defer srv.Close()
+
+	tests := []struct {
+		name           string
+		secret         string
+		wantRestricted bool
+		wantRequests   int
+		wantForbidden  bool
+		wantNotFound   bool
+	}{
+		{name: "data readable", secret: "full", wantRequests: 1},
+		{name: "metadata get allowed", secret: "metadata", wantRestricted: true, wantRequests: 2},
+		{name: "metadata list by name allowed", secret: "list", wantRestricted: true, wantRequests: 3},
+		{name: "nothing allowed", secret: "forbidden", wantRequests: 3, wantForbidden: true},
+		{name: "missing", secret: "missing", wantNotFound: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kclient, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			lookup := newSecretLookup(kclient)
+			*requests = 0
+
+			secret, err := lookup.get(selfTestNamespace, tt.secret)
+			switch {
+			case tt.wantForbidden:
+				// The original error is kept, not the fallback's
+				if !apierrors.IsForbidden(err) {
+					t.Fatalf("get() error = %v, want forbidden", err)
+				}
+			case tt.wantNotFound:
+				if !apierrors.IsNotFound(err) {
+					t.Fatalf("get() error = %v, want not found", err)
+				}
+			case err != nil:
+				t.Fatalf("get() error = %v", err)
+			default:
+				if secret.Name != tt.secret || secret.Annotations["cert-manager.io/certificate-name"] != tt.secret || secret.CreationTimestamp.IsZero() {
+					t.Errorf("get() = %+v, want the secret metadata", secret.ObjectMeta)
+				}
+				if got := lookup.isRestricted(*secret); got != tt.wantRestricted {
+					t.Errorf("isRestricted() = %v, want %v", got, tt.wantRestricted)
+				}
+
+				// Fetched once per namespace scan
+				if _, err := lookup.get(selfTestNamespace, tt.secret); err != nil {
+					t.Fatalf("second get() error = %v", err)
+				}
+			}
+			if tt.wantRequests > 0 && *requests != tt.wantRequests {
+				t.Errorf("%d requests for the secret, want %d", *requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestScanRestrictedSecrets(t *testing.T) {
+	defer func(days int, recheck bool) { *warnDays, *noRecheck = days, recheck }(*warnDays, *noRecheck)
+	*warnDays, *noRecheck = 30, true
+
+	now := time.Now()
+	cases := selfTestCases(now)
+	secrets := map[string]*corev1.Secret{}
+	for _, tc := range cases {
+		if tc.build == nil {
+			continue
+		}
+		secret, err := tc.build()
+		if err != nil {
+			t.Fatalf("building %s: %v", tc.name, err)
+		}
+		secrets[tc.secret] = secret
+	}
+	upstream := selfTestServer(cases, secrets)
+	defer upstream.Close()
+
+	// The valid secret is only readable as metadata
+	srv, _ := restrictedSecretsServer(t, map[string]string{"valid": accessMetadata}, upstream)
+	defer srv.Close()
+	kclient, dclient, err := newClients(&rest.Config{Host: srv.URL, QPS: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scan := &scanContext{buffered: true, drift: newDriftTracker(nil), sources: map[string]bool{sourceIstio: true}}
+	scan.scanNamespace(kclient, dclient, selfTestNamespace, newAbsentGroups(scan.cluster, nil))
+	bySecret := map[string]result{}
+	for _, r := range scan.results {
+		bySecret[r.Secret] = r
+	}
+
+	tests := []struct {
+		secret     string
+		wantStatus string
+		wantCode   string
+		wantFound  bool
+	}{
+		{secret: "valid", wantStatus: statusUnknown, wantCode: findingContentForbidden, wantFound: true},
+		{secret: "expiring", wantStatus: statusWarning, wantCode: findingCertExpiring, wantFound: true},
+		{secret: "expired", wantStatus: statusExpired, wantCode: findingCertExpired, wantFound: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.secret, func(t *testing.T) {
+			r, ok := bySecret[tt.secret]
+			if ok != tt.wantFound {
+				t.Fatalf("result for %s found %v, want %v", tt.secret, ok, tt.wantFound)
+			}
+			if ok && (r.Status != tt.wantStatus || r.Code != tt.wantCode) {
+				t.Errorf("result = %s, %s, want %s, %s", r.Status, r.Code, tt.wantStatus, tt.wantCode)
+			}
+			if ok && tt.wantCode == findingContentForbidden && (r.ManagedBy != "cert-manager" || !strings.Contains(r.text, "content not accessible, expiry unknown")) {
+				t.Errorf("restricted result managed by %q: %s", r.ManagedBy, r.text)
+			}
+		})
+	}
+
+	if len(scan.errors) != 0 {
+		t.Errorf("scan errors = %v, want none", scan.errors)
+	}
+}
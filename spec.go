@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func validateServerTLS(server map[string]interface{}) []string {
+	var problems []string
+
+	protocol, _, _ := unstructured.NestedString(server, "port", "protocol")
+	protocol = strings.ToUpper(protocol)
+	tls, hasTLS, _ := unstructured.NestedMap(server, "tls")
+	mode, _, _ := unstructured.NestedString(tls, "mode")
+
+	// httpsRedirect is the only tls setting meaningful on a plain text server
+	onlyRedirect := true
+	for key := range tls {
+		if key != "httpsRedirect" {
+			onlyRedirect = false
+		}
+	}
+
+	switch protocol {
+	case "HTTPS", "TLS":
+		if !hasTLS || onlyRedirect {
+			problems = append(problems, fmt.Sprintf("protocol %s requires a tls block", protocol))
+		}
+		if protocol == "HTTPS" && (mode == "PASSTHROUGH" || mode == "AUTO_PASSTHROUGH") {
+			problems = append(problems, fmt.Sprintf("protocol HTTPS terminates TLS, tls mode %s requires protocol TLS", mode))
+		}
+	default:
+		if hasTLS && !onlyRedirect {
+			problems = append(problems, fmt.Sprintf("protocol %s is not TLS, the tls block is ignored", protocol))
+		}
+	}
+
+	// Terminating modes need a certificate unless Istio provides it
+	if hasTLS && !onlyRedirect && (protocol == "HTTPS" || protocol == "TLS") {
+		switch mode {
+		case "", "SIMPLE", "MUTUAL", "OPTIONAL_MUTUAL":
+			credentialName, _, _ := unstructured.NestedString(tls, "credentialName")
+			serverCert, _, _ := unstructured.NestedString(tls, "serverCertificate")
+			if credentialName == "" && serverCert == "" {
+				problems = append(problems, "tls block sets neither credentialName nor serverCertificate")
+			}
+		}
+	}
+
+	return problems
+}
+
+func checkGatewaySpec(w io.Writer, gw unstructured.Unstructured) {
+	servers, _, _ := unstructured.NestedSlice(gw.Object, "spec", "servers")
+	for _, serverObj := range servers {
+		server, ok := serverObj.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		port, _, _ := unstructured.NestedInt64(server, "port", "number")
+		for _, problem := range validateServerTLS(server) {
+			fmt.Fprintf(w, "Misconfiguration in gateway %s in namespace %s server port %d: %s\n", gw.GetName(), gw.GetNamespace(), port, problem)
+		}
+
+		hosts, _, _ := unstructured.NestedStringSlice(server, "hosts")
+		for _, host := range hosts {
+			if _, _, err := parseGatewayHost(host); err != nil {
+				fmt.Fprintf(w, "Warning: malformed host %q in gateway %s in namespace %s server port %d: %v\n", host, gw.GetName(), gw.GetNamespace(), port, err)
+			}
+		}
+	}
+}
+
+func parseGatewayHost(host string) (string, string, error) {
+	if strings.Contains(host, "://") {
+		return "", "", fmt.Errorf("host must not include a URL scheme")
+	}
+	if strings.ContainsAny(host, " \t") {
+		return "", "", fmt.Errorf("host must not contain whitespace")
+	}
+
+	// Optional namespace prefix: "*/", "./" or "<namespace>/"
+	ns, name := "", host
+	if i := strings.Index(host, "/"); i >= 0 {
+		ns, name = host[:i], host[i+1:]
+		if ns != "*" && ns != "." && len(validation.IsDNS1123Label(ns)) > 0 {
+			return "", "", fmt.Errorf("invalid namespace prefix %q", ns)
+		}
+	}
+
+	switch {
+	case name == "":
+		return "", "", fmt.Errorf("host is empty")
+	case name == "*":
+	case strings.HasSuffix(name, "."):
+		return "", "", fmt.Errorf("host must not end with a dot")
+	case strings.HasPrefix(name, "*"):
+		if errs := validation.IsWildcardDNS1123Subdomain(strings.ToLower(name)); len(errs) > 0 {
+			return "", "", fmt.Errorf("invalid wildcard host: %s", errs[0])
+		}
+	case net.ParseIP(strings.Trim(name, "[]")) != nil:
+	default:
+		if errs := validation.IsDNS1123Subdomain(strings.ToLower(name)); len(errs) > 0 {
+			return "", "", fmt.Errorf("invalid host: %s", errs[0])
+		}
+	}
+
+	return ns, name, nil
+}
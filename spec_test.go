@@ -0,0 +1,109 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateServerTLS(t *testing.T) {
+	server := func(protocol string, tls map[string]interface{}) map[string]interface{} {
+		s := map[string]interface{}{"port": map[string]interface{}{"number": int64(443), "protocol": protocol}}
+		if tls != nil {
+			s["tls"] = tls
+		}
+		return s
+	}
+	simple := map[string]interface{}{"mode": "SIMPLE", "credentialName": "cert"}
+	redirect := map[string]interface{}{"httpsRedirect": true}
+
+	tests := []struct {
+		name   string
+		server map[string]interface{}
+		want   []string
+	}{
+		{name: "https with tls", server: server("HTTPS", simple)},
+		{name: "tls with tls", server: server("TLS", simple)},
+		{name: "lower case protocol", server: server("https", simple)},
+		{name: "http without tls", server: server("HTTP", nil)},
+		{name: "http with redirect", server: server("HTTP", redirect)},
+		{name: "tcp without tls", server: server("TCP", nil)},
+		{name: "https without tls", server: server("HTTPS", nil), want: []string{"protocol HTTPS requires a tls block"}},
+		{name: "tls without tls", server: server("TLS", nil), want: []string{"protocol TLS requires a tls block"}},
+		{name: "https with only redirect", server: server("HTTPS", redirect), want: []string{"protocol HTTPS requires a tls block"}},
+		{name: "http with tls", server: server("HTTP", simple), want: []string{"protocol HTTP is not TLS, the tls block is ignored"}},
+		{name: "grpc with tls", server: server("GRPC", simple), want: []string{"protocol GRPC is not TLS, the tls block is ignored"}},
+		{name: "https passthrough", server: server("HTTPS", map[string]interface{}{"mode": "PASSTHROUGH"}), want: []string{"protocol HTTPS terminates TLS, tls mode PASSTHROUGH requires protocol TLS"}},
+		{name: "tls passthrough", server: server("TLS", map[string]interface{}{"mode": "PASSTHROUGH"})},
+		{name: "tls auto passthrough", server: server("TLS", map[string]interface{}{"mode": "AUTO_PASSTHROUGH"})},
+		{name: "istio mutual", server: server("HTTPS", map[string]interface{}{"mode": "ISTIO_MUTUAL"})},
+		{name: "file certificate", server: server("HTTPS", map[string]interface{}{"mode": "SIMPLE", "serverCertificate": "/etc/certs/tls.crt"})},
+		{name: "simple without certificate", server: server("HTTPS", map[string]interface{}{"mode": "SIMPLE"}), want: []string{"tls block sets neither credentialName nor serverCertificate"}},
+		{name: "default mode without certificate", server: server("TLS", map[string]interface{}{"minProtocolVersion": "TLSV1_2"}), want: []string{"tls block sets neither credentialName nor serverCertificate"}},
+		{name: "mutual without certificate", server: server("HTTPS", map[string]interface{}{"mode": "MUTUAL"}), want: []string{"tls block sets neither credentialName nor serverCertificate"}},
+		{name: "http with tls without certificate", server: server("HTTP", map[string]interface{}{"mode": "SIMPLE"}), want: []string{"protocol HTTP is not TLS, the tls block is ignored"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateServerTLS(tt.server); !slices.Equal(got, tt.want) {
+				t.Errorf("validateServerTLS() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGatewayHost(t *testing.T) {
+	tests := []struct {
+		host     string
+		wantNS   string
+		wantName string
+		wantErr  string
+	}{
+		{host: "shop.example.com", wantName: "shop.example.com"},
+		{host: "Shop.Example.com", wantName: "Shop.Example.com"},
+		{host: "*", wantName: "*"},
+		{host: "*.example.com", wantName: "*.example.com"},
+		{host: "*/shop.example.com", wantNS: "*", wantName: "shop.example.com"},
+		{host: "./shop.example.com", wantNS: ".", wantName: "shop.example.com"},
+		{host: "apps/*.example.com", wantNS: "apps", wantName: "*.example.com"},
+		{host: "*/*", wantNS: "*", wantName: "*"},
+		{host: "10.0.0.1", wantName: "10.0.0.1"},
+		{host: "2001:db8::1", wantName: "2001:db8::1"},
+		{host: "[2001:db8::1]", wantName: "[2001:db8::1]"},
+		{host: "https://shop.example.com", wantErr: "host must not include a URL scheme"},
+		{host: "shop.example.com.", wantErr: "host must not end with a dot"},
+		{host: "shop .example.com", wantErr: "host must not contain whitespace"},
+		{host: " shop.example.com", wantErr: "host must not contain whitespace"},
+		{host: "shop.example.com\t", wantErr: "host must not contain whitespace"},
+		{host: "", wantErr: "host is empty"},
+		{host: "apps/", wantErr: "host is empty"},
+		{host: "Apps/shop.example.com", wantErr: `invalid namespace prefix "Apps"`},
+		{host: "my_ns/shop.example.com", wantErr: `invalid namespace prefix "my_ns"`},
+		{host: "/shop.example.com", wantErr: `invalid namespace prefix ""`},
+		{host: "shop.*.example.com", wantErr: "invalid host"},
+		{host: "*shop.example.com", wantErr: "invalid wildcard host"},
+		{host: "*.*.example.com", wantErr: "invalid wildcard host"},
+		{host: "shop_1.example.com", wantErr: "invalid host"},
+		{host: "-shop.example.com", wantErr: "invalid host"},
+		{host: "shop.example.com:443", wantErr: "invalid host"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			ns, name, err := parseGatewayHost(tt.host)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("parseGatewayHost(%q) error = %v, want %q", tt.host, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseGatewayHost(%q) error = %v", tt.host, err)
+			}
+			if ns != tt.wantNS || name != tt.wantName {
+				t.Errorf("parseGatewayHost(%q) = %q, %q, want %q, %q", tt.host, ns, name, tt.wantNS, tt.wantName)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IANA zone set on a secret or its namespace, rendered next to UTC in human-readable output
+const reportTimezoneAnnotation = "check-secrets/report-timezone"
+
+// Invalid zone names are reported once per run
+var warnedZones sync.Map
+
+func annotatedZone(obj metav1.Object) *time.Location {
+	name := obj.GetAnnotations()[reportTimezoneAnnotation]
+	if name == "" {
+		return nil
+	}
+
+	zone, err := time.LoadLocation(name)
+	if err != nil {
+		if _, warned := warnedZones.LoadOrStore(name, true); !warned {
+			fmt.Fprintf(os.Stderr, "warning: invalid %s %q, using UTC: %v\n", reportTimezoneAnnotation, name, err)
+		}
+		return nil
+	}
+
+	return zone
+}
+
+func formatExpiry(notAfter time.Time, zone *time.Location) string {
+	expiry := notAfter.UTC().Format(opensslTimeFormat)
+	if zone != nil && zone != time.UTC {
+		expiry += " / " + notAfter.In(zone).Format("2006-01-02 15:04 MST")
+	}
+
+	return expiry
+}
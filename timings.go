@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const slowestNamespaces = 10
+
+// Wall-clock time spent per namespace and per scan step, collected for --show-timings
+type scanTimings struct {
+	mu         sync.Mutex
+	namespaces map[string]time.Duration
+	steps      map[string]time.Duration
+}
+
+func newScanTimings() *scanTimings {
+	return &scanTimings{
+		namespaces: map[string]time.Duration{},
+		steps:      map[string]time.Duration{},
+	}
+}
+
+func (t *scanTimings) step(name string, start time.Time) {
+	if t != nil {
+		t.mu.Lock()
+		t.steps[name] += time.Since(start)
+		t.mu.Unlock()
+	}
+}
+
+func (t *scanTimings) namespace(name string, start time.Time) {
+	if t != nil {
+		t.mu.Lock()
+		t.namespaces[name] += time.Since(start)
+		t.mu.Unlock()
+	}
+}
+
+func sortedByDuration(durations map[string]time.Duration) []string {
+	var names []string
+	for name := range durations {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if durations[names[i]] != durations[names[j]] {
+			return durations[names[i]] > durations[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	return names
+}
+
+func (t *scanTimings) report() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Stderr keeps the timings out of anything parsing the report
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tDURATION")
+	for _, name := range sortedByDuration(t.steps) {
+		fmt.Fprintf(w, "%s\t%s\n", name, t.steps[name].Round(time.Millisecond))
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "NAMESPACE\tDURATION")
+	names := sortedByDuration(t.namespaces)
+	if len(names) > slowestNamespaces {
+		names = names[:slowestNamespaces]
+	}
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", name, t.namespaces[name].Round(time.Millisecond))
+	}
+	w.Flush()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+)
+
+type manifestFiles []string
+
+func (f *manifestFiles) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *manifestFiles) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func manifestPaths(inputs []string) ([]string, error) {
+	var paths []string
+	for _, input := range inputs {
+		info, err := os.Stat(input)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read manifest: %v", err)
+		}
+		if !info.IsDir() {
+			paths = append(paths, input)
+			continue
+		}
+
+		entries, err := os.ReadDir(input)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read manifest directory: %v", err)
+		}
+		for _, entry := range entries {
+			switch filepath.Ext(entry.Name()) {
+			case ".yaml", ".yml", ".json":
+				if !entry.IsDir() {
+					paths = append(paths, filepath.Join(input, entry.Name()))
+				}
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+func readManifests(path string) ([]unstructured.Unstructured, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Files may hold several YAML documents
+	var objects []unstructured.Unstructured
+	decoder := yaml.NewYAMLOrJSONDecoder(file, 4096)
+	for {
+		var obj map[string]interface{}
+		err := decoder.Decode(&obj)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %v", path, err)
+		}
+		if obj != nil {
+			objects = append(objects, unstructured.Unstructured{Object: obj})
+		}
+	}
+
+	return objects, nil
+}
+
+// Runs the admission webhook checks against local manifests and live secrets
+func validateManifests(kclient *kubernetes.Clientset, inputs []string, namespace string, rules webhookRules) (bool, error) {
+	err := rules.validate()
+	if err != nil {
+		return false, err
+	}
+	if len(inputs) == 0 {
+		return false, fmt.Errorf("no manifests given, use -f FILE or -f DIR")
+	}
+
+	paths, err := manifestPaths(inputs)
+	if err != nil {
+		return false, err
+	}
+
+	handler := &admissionHandler{kclient: kclient, rules: rules}
+	passed, gateways := true, 0
+	for _, path := range paths {
+		objects, err := readManifests(path)
+		if err != nil {
+			return false, err
+		}
+
+		for _, obj := range objects {
+			gvk := obj.GroupVersionKind()
+			if gvk.Group != gatewayResource.Group || gvk.Kind != "Gateway" {
+				continue
+			}
+			gateways++
+
+			ns := obj.GetNamespace()
+			if ns == "" {
+				ns = namespace
+			}
+			raw, err := obj.MarshalJSON()
+			if err != nil {
+				return false, fmt.Errorf("unable to encode gateway %s in %s: %v", obj.GetName(), path, err)
+			}
+
+			response := handler.review(context.TODO(), &admissionv1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind},
+				Namespace: ns,
+				Name:      obj.GetName(),
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			})
+
+			for _, warning := range response.Warnings {
+				fmt.Printf("%s: gateway %s in namespace %s: warning: %s\n", path, obj.GetName(), ns, warning)
+			}
+			if !response.Allowed {
+				passed = false
+				fmt.Printf("%s: gateway %s in namespace %s: denied: %s\n", path, obj.GetName(), ns, response.Result.Message)
+			} else if len(response.Warnings) == 0 {
+				fmt.Printf("%s: gateway %s in namespace %s: ok\n", path, obj.GetName(), ns)
+			}
+		}
+	}
+
+	if gateways == 0 {
+		return false, fmt.Errorf("no Istio gateways found in %s", strings.Join(paths, ", "))
+	}
+
+	return passed, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+// Versions tried for each CRD, newest first: older clusters lack the new
+// ones and newer releases may stop serving the old ones
+var resourceVersions = map[schema.GroupResource][]string{
+	gatewayResource.GroupResource():        {"v1", "v1beta1", "v1alpha3"},
+	kubeGatewayResource.GroupResource():    {"v1", "v1beta1"},
+	referenceGrantResource.GroupResource(): {"v1", "v1beta1", "v1alpha2"},
+}
+
+// Dynamic client sending CRD requests to the newest version the cluster serves
+type servedVersionClient struct {
+	dynamic.Interface
+	discovery discovery.DiscoveryInterface
+
+	mu       sync.Mutex
+	resolved map[schema.GroupResource]string
+}
+
+func newServedVersionClient(dclient dynamic.Interface, discovery discovery.DiscoveryInterface) *servedVersionClient {
+	return &servedVersionClient{Interface: dclient, discovery: discovery, resolved: map[schema.GroupResource]string{}}
+}
+
+func (c *servedVersionClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	if versions, ok := resourceVersions[gvr.GroupResource()]; ok {
+		gvr.Version = c.servedVersion(gvr, versions)
+	}
+
+	return c.Interface.Resource(gvr)
+}
+
+func (c *servedVersionClient) servedVersion(gvr schema.GroupVersionResource, versions []string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version, ok := c.resolved[gvr.GroupResource()]; ok {
+		return version
+	}
+
+	// Without a match the default is kept, a missing CRD then fails the request as before
+	version := gvr.Version
+	for _, v := range versions {
+		resources, err := c.discovery.ServerResourcesForGroupVersion(gvr.Group + "/" + v)
+		if err != nil {
+			continue
+		}
+
+		found := false
+		for _, resource := range resources.APIResources {
+			found = found || resource.Name == gvr.Resource
+		}
+		if found {
+			version = v
+			break
+		}
+	}
+	debugf("Using %s/%s for %s", gvr.Group, version, gvr.Resource)
+	c.resolved[gvr.GroupResource()] = version
+
+	return version
+}
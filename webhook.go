@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ArnauSB/check-secrets/certs"
+	admissionv1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Actions a webhook rule can take on a violation
+const (
+	ruleDeny = "deny"
+	ruleWarn = "warn"
+	ruleOff  = "off"
+)
+
+type webhookRules struct {
+	missingSecret string
+	invalidCert   string
+	expiredCert   string
+	sanCoverage   string
+	failOpen      bool
+
+	// Expiring and stale certificates only ever warn, as in a scan
+	warnDays             int
+	staleInstallFraction float64
+}
+
+func (r webhookRules) validate() error {
+	for name, action := range map[string]string{
+		"missing-secret": r.missingSecret,
+		"invalid-cert":   r.invalidCert,
+		"expired-cert":   r.expiredCert,
+		"san-coverage":   r.sanCoverage,
+	} {
+		if action != ruleDeny && action != ruleWarn && action != ruleOff {
+			return fmt.Errorf("invalid action %q for rule %s, expected deny, warn or off", action, name)
+		}
+	}
+
+	return nil
+}
+
+type admissionHandler struct {
+	kclient *kubernetes.Clientset
+	rules   webhookRules
+}
+
+func (h *admissionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "unable to read request body", http.StatusBadRequest)
+		return
+	}
+
+	var review admissionv1.AdmissionReview
+	err = json.Unmarshal(body, &review)
+	if err != nil || review.Request == nil {
+		http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+		return
+	}
+
+	response := h.review(r.Context(), review.Request)
+	response.UID = review.Request.UID
+	review.Request = nil
+	review.Response = response
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(review)
+	if err != nil {
+		fmt.Println("error writing admission response:", err)
+	}
+}
+
+func (h *admissionHandler) review(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	response := &admissionv1.AdmissionResponse{Allowed: true}
+
+	// Deletes and connects carry no object to check and must never be blocked
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return response
+	}
+
+	// Every served version (v1alpha3, v1beta1, v1) shares the same servers layout
+	if req.Kind.Group != gatewayResource.Group || req.Kind.Kind != "Gateway" {
+		return response
+	}
+
+	var (
+		gw      unstructured.Unstructured
+		denials []string
+	)
+
+	err := gw.UnmarshalJSON(req.Object.Raw)
+	if err != nil {
+		return h.internalError(response, fmt.Sprintf("unable to decode gateway: %v", err))
+	}
+	if gw.GetNamespace() == "" {
+		gw.SetNamespace(req.Namespace)
+	}
+
+	apply := func(action, message string) {
+		switch action {
+		case ruleDeny:
+			denials = append(denials, message)
+		case ruleWarn:
+			response.Warnings = append(response.Warnings, message)
+		}
+	}
+
+	servers, _, _ := unstructured.NestedSlice(gw.Object, "spec", "servers")
+	for i, serverObj := range servers {
+		server, ok := serverObj.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		mode, _, _ := unstructured.NestedString(server, "tls", "mode")
+		credentialName, _, _ := unstructured.NestedString(server, "tls", "credentialName")
+		if mode == "PASSTHROUGH" || credentialName == "" {
+			continue
+		}
+
+		secret, err := h.kclient.CoreV1().Secrets(gw.GetNamespace()).Get(ctx, credentialName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			apply(h.rules.missingSecret, fmt.Sprintf("server %d: secret %s not found in namespace %s", i, credentialName, gw.GetNamespace()))
+			continue
+		}
+		if err != nil {
+			h.internalError(response, fmt.Sprintf("server %d: unable to verify secret %s: %v", i, credentialName, err))
+			if !response.Allowed {
+				return response
+			}
+			continue
+		}
+
+		// Same checks as a scan, the CA bundle of a mutual server verifies clients instead
+		mutual := mode == "MUTUAL" || mode == "OPTIONAL_MUTUAL"
+		finding, err := certs.Evaluate(*secret, certs.EvalOptions{WarnDays: h.rules.warnDays, StaleInstallFraction: h.rules.staleInstallFraction, Now: clock(), VerifyChain: !mutual})
+		if err != nil {
+			apply(h.rules.invalidCert, fmt.Sprintf("server %d: secret %s has no valid certificate: %v", i, credentialName, err))
+			continue
+		}
+		chain := finding.Chain
+
+		switch finding.Status {
+		case certs.StatusExpired:
+			apply(h.rules.expiredCert, fmt.Sprintf("server %d: certificate in secret %s expired on %s", i, credentialName, finding.NotAfter.UTC().Format(opensslTimeFormat)))
+		case certs.StatusWarning:
+			apply(ruleWarn, fmt.Sprintf("server %d: certificate in secret %s expires on %s, in %d days", i, credentialName, finding.NotAfter.UTC().Format(opensslTimeFormat), finding.DaysRemaining))
+		}
+		if finding.ChainError != "" {
+			apply(h.rules.invalidCert, fmt.Sprintf("server %d: certificate in secret %s: %s", i, credentialName, finding.ChainError))
+		}
+		if finding.KeyError != "" {
+			apply(h.rules.invalidCert, fmt.Sprintf("server %d: private key in secret %s: %s", i, credentialName, finding.KeyError))
+		}
+		for _, n := range finding.EarlyIntermediates {
+			apply(ruleWarn, fmt.Sprintf("server %d: intermediate certificate %d in secret %s expires on %s, before the leaf", i, n, credentialName, chain[n].NotAfter.UTC().Format(opensslTimeFormat)))
+		}
+		if finding.StaleInstall {
+			apply(ruleWarn, fmt.Sprintf("server %d: stale certificate installed in secret %s with %.0f%% of its lifetime left", i, credentialName, finding.LifetimeLeft*100))
+		}
+
+		hosts, _, _ := unstructured.NestedStringSlice(server, "hosts")
+		for _, host := range hosts {
+			if _, _, err := parseGatewayHost(host); err != nil {
+				apply(h.rules.sanCoverage, fmt.Sprintf("server %d: malformed host %q: %v", i, host, err))
+				continue
+			}
+			if !hostCovered(host, chain[0]) {
+				apply(h.rules.sanCoverage, fmt.Sprintf("server %d: host %s not covered by the certificate in secret %s", i, host, credentialName))
+			}
+		}
+	}
+
+	if len(denials) > 0 {
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusForbidden,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: strings.Join(denials, "; "),
+		}
+	}
+
+	return response
+}
+
+func (h *admissionHandler) internalError(response *admissionv1.AdmissionResponse, message string) *admissionv1.AdmissionResponse {
+	// Mirror failurePolicy: Ignore by admitting with a warning when running fail-open
+	if h.rules.failOpen {
+		response.Warnings = append(response.Warnings, message)
+		return response
+	}
+
+	response.Allowed = false
+	response.Result = &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusInternalServerError,
+		Reason:  metav1.StatusReasonInternalError,
+		Message: message,
+	}
+
+	return response
+}
+
+func serveWebhook(kclient *kubernetes.Clientset, addr string, tlsOpts listenerTLS, rules webhookRules) error {
+	err := rules.validate()
+	if err != nil {
+		return err
+	}
+
+	if tlsOpts.secretRef == "" && (tlsOpts.certFile == "" || tlsOpts.keyFile == "") {
+		return fmt.Errorf("the webhook requires --tls-cert-file and --tls-key-file or --tls-secret")
+	}
+
+	fmt.Printf("Serving admission webhook on %s\n", addr)
+
+	return listen(kclient, addr, tlsOpts, map[string]http.Handler{
+		"/validate": &admissionHandler{kclient: kclient, rules: rules},
+	})
+}
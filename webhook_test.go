@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func webhookServer(mode, credentialName string, hosts ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"port":  map[string]interface{}{"number": 443, "name": "https", "protocol": "HTTPS"},
+		"hosts": hosts,
+		"tls":   map[string]interface{}{"mode": mode, "credentialName": credentialName},
+	}
+}
+
+func admissionRequest(t *testing.T, group, version, kind string, servers ...map[string]interface{}) *admissionv1.AdmissionRequest {
+	t.Helper()
+	raw, err := json.Marshal(map[string]interface{}{
+		"apiVersion": group + "/" + version,
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": "gw"},
+		"spec":       map[string]interface{}{"servers": servers},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &admissionv1.AdmissionRequest{
+		UID:       "a6b5c2d4",
+		Kind:      metav1.GroupVersionKind{Group: group, Version: version, Kind: kind},
+		Namespace: selfTestNamespace,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+// Deletes carry the old object only, connects none
+func withOperation(req *admissionv1.AdmissionRequest, op admissionv1.Operation) *admissionv1.AdmissionRequest {
+	req.Operation = op
+	if op != admissionv1.Update {
+		req.Object = runtime.RawExtension{}
+	}
+	return req
+}
+
+func TestAdmissionReview(t *testing.T) {
+	now := time.Now()
+	clock = func() time.Time { return now }
+	defer func() { clock = time.Now }()
+
+	cases := selfTestCases(now)
+	secrets := map[string]*corev1.Secret{}
+	for _, tc := range cases {
+		if tc.build == nil {
+			continue
+		}
+		secret, err := tc.build()
+		if err != nil {
+			t.Fatalf("building %s: %v", tc.name, err)
+		}
+		secrets[tc.secret] = secret
+	}
+	srv := selfTestServer(cases, secrets)
+	defer srv.Close()
+	// No client-side throttling across the cases
+	kclient, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL, QPS: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Refuses connections, for API errors
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	downClient, err := kubernetes.NewForConfig(&rest.Config{Host: down.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := webhookRules{missingSecret: ruleDeny, invalidCert: ruleDeny, expiredCert: ruleDeny, sanCoverage: ruleWarn, warnDays: 30, staleInstallFraction: 0.5}
+	istio := gatewayResource.Group
+
+	tests := []struct {
+		name         string
+		req          *admissionv1.AdmissionRequest
+		rules        func(*webhookRules)
+		kclient      *kubernetes.Clientset
+		wantAllowed  bool
+		wantDenial   string
+		wantCode     int32
+		wantWarnings []string
+	}{
+		{name: "v1alpha3 valid", req: admissionRequest(t, istio, "v1alpha3", "Gateway", webhookServer("SIMPLE", "valid", "valid.example.com")), wantAllowed: true},
+		{name: "v1beta1 valid", req: admissionRequest(t, istio, "v1beta1", "Gateway", webhookServer("SIMPLE", "valid", "valid.example.com")), wantAllowed: true},
+		{name: "v1 valid", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "valid", "valid.example.com")), wantAllowed: true},
+		{name: "v1alpha3 expired", req: admissionRequest(t, istio, "v1alpha3", "Gateway", webhookServer("SIMPLE", "expired", "expired.example.com")), wantDenial: "server 0: certificate in secret expired expired on", wantCode: http.StatusForbidden},
+		{name: "v1beta1 missing secret", req: admissionRequest(t, istio, "v1beta1", "Gateway", webhookServer("SIMPLE", "missing")), wantDenial: "server 0: secret missing not found in namespace self-test", wantCode: http.StatusForbidden},
+		{name: "v1 invalid certificate", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "der")), wantDenial: "server 0: secret der has no valid certificate", wantCode: http.StatusForbidden},
+		{
+			name:        "every denial reported",
+			req:         admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "valid"), webhookServer("SIMPLE", "expired"), webhookServer("SIMPLE", "missing")),
+			wantDenial:  "server 1: certificate in secret expired expired on",
+			wantCode:    http.StatusForbidden,
+			wantAllowed: false,
+		},
+		{name: "expired allowed with warn", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "expired")), rules: func(r *webhookRules) { r.expiredCert = ruleWarn }, wantAllowed: true, wantWarnings: []string{"server 0: certificate in secret expired expired on"}},
+		{name: "expired rule off", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "expired")), rules: func(r *webhookRules) { r.expiredCert = ruleOff }, wantAllowed: true},
+		{name: "expiring warns", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "expiring")), wantAllowed: true, wantWarnings: []string{"server 0: certificate in secret expiring expires on"}},
+		{name: "stale install warns", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "stale")), wantAllowed: true, wantWarnings: []string{"expires on", "stale certificate installed in secret stale"}},
+		{name: "early intermediate warns", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "chain")), wantAllowed: true, wantWarnings: []string{"intermediate certificate 1 in secret chain expires on"}},
+		{name: "wrong key", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "wrong-key")), wantDenial: "server 0: private key in secret wrong-key", wantCode: http.StatusForbidden},
+		{name: "chain not verifying", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "ca-foreign")), wantDenial: "server 0: certificate in secret ca-foreign: chain does not verify", wantCode: http.StatusForbidden},
+		{name: "invalid certificate with warn", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "wrong-key"), webhookServer("SIMPLE", "ca-foreign"), webhookServer("SIMPLE", "missing")), rules: func(r *webhookRules) { r.invalidCert = ruleWarn }, wantDenial: "server 2: secret missing not found", wantCode: http.StatusForbidden, wantWarnings: []string{"server 0: private key in secret wrong-key", "server 1: certificate in secret ca-foreign: chain does not verify"}},
+		{name: "missing secret with warn", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "wrong-key"), webhookServer("SIMPLE", "missing")), rules: func(r *webhookRules) { r.missingSecret = ruleWarn }, wantDenial: "server 0: private key in secret wrong-key", wantCode: http.StatusForbidden, wantWarnings: []string{"server 1: secret missing not found"}},
+		{name: "mutual client ca", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("MUTUAL", "ca-foreign")), wantAllowed: true},
+		{name: "host not covered", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "valid", "shop.example.com")), wantAllowed: true, wantWarnings: []string{"server 0: host shop.example.com not covered by the certificate in secret valid"}},
+		{name: "host not covered denied", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "valid", "shop.example.com")), rules: func(r *webhookRules) { r.sanCoverage = ruleDeny }, wantDenial: "not covered", wantCode: http.StatusForbidden},
+		{name: "malformed host", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "valid", "https://valid.example.com")), wantAllowed: true, wantWarnings: []string{`server 0: malformed host "https://valid.example.com"`}},
+		{name: "namespace prefixed host", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "valid", "self-test/valid.example.com")), wantAllowed: true},
+		{name: "any host", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "valid", "*")), wantAllowed: true},
+		{name: "passthrough skipped", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("PASSTHROUGH", "missing")), wantAllowed: true},
+		{name: "no credential name", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("ISTIO_MUTUAL", "")), wantAllowed: true},
+		{name: "other kind", req: admissionRequest(t, istio, "v1", "VirtualService", webhookServer("SIMPLE", "missing")), wantAllowed: true},
+		{name: "kubernetes gateway api", req: admissionRequest(t, "gateway.networking.k8s.io", "v1", "Gateway", webhookServer("SIMPLE", "missing")), wantAllowed: true},
+		{name: "delete", req: withOperation(admissionRequest(t, istio, "v1", "Gateway"), admissionv1.Delete), wantAllowed: true},
+		{name: "connect", req: withOperation(admissionRequest(t, istio, "v1", "Gateway"), admissionv1.Connect), wantAllowed: true},
+		{name: "update", req: withOperation(admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "expired")), admissionv1.Update), wantDenial: "server 0: certificate in secret expired expired on", wantCode: http.StatusForbidden},
+		{name: "api error", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "valid")), kclient: downClient, wantDenial: "server 0: unable to verify secret valid", wantCode: http.StatusInternalServerError},
+		{name: "api error fail open", req: admissionRequest(t, istio, "v1", "Gateway", webhookServer("SIMPLE", "valid")), kclient: downClient, rules: func(r *webhookRules) { r.failOpen = true }, wantAllowed: true, wantWarnings: []string{"server 0: unable to verify secret valid"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &admissionHandler{kclient: kclient, rules: rules}
+			if tt.kclient != nil {
+				h.kclient = tt.kclient
+			}
+			if tt.rules != nil {
+				tt.rules(&h.rules)
+			}
+
+			body, err := json.Marshal(admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+				Request:  tt.req,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+
+			var review admissionv1.AdmissionReview
+			if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+				t.Fatal(err)
+			}
+			response := review.Response
+			if response == nil || review.Request != nil {
+				t.Fatalf("review = %+v, want only a response", review)
+			}
+			if response.UID != tt.req.UID {
+				t.Errorf("response UID = %s, want %s", response.UID, tt.req.UID)
+			}
+			if response.Allowed != tt.wantAllowed {
+				t.Errorf("allowed = %v, want %v (%+v)", response.Allowed, tt.wantAllowed, response.Result)
+			}
+			if tt.wantDenial != "" {
+				if response.Result == nil || !strings.Contains(response.Result.Message, tt.wantDenial) || response.Result.Code != tt.wantCode {
+					t.Errorf("result = %+v, want code %d and a message containing %q", response.Result, tt.wantCode, tt.wantDenial)
+				}
+			}
+			if len(response.Warnings) != len(tt.wantWarnings) {
+				t.Fatalf("warnings = %q, want %d", response.Warnings, len(tt.wantWarnings))
+			}
+			for i, want := range tt.wantWarnings {
+				if !strings.Contains(response.Warnings[i], want) {
+					t.Errorf("warning %d = %q, want it to contain %q", i, response.Warnings[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestAdmissionHandlerRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{"get", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"not json", http.MethodPost, "{", http.StatusBadRequest},
+		{"no request", http.MethodPost, `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h := &admissionHandler{rules: webhookRules{missingSecret: ruleDeny, invalidCert: ruleDeny, expiredCert: ruleDeny, sanCoverage: ruleWarn}}
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, "/validate", strings.NewReader(tt.body)))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookRulesValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   webhookRules
+		wantErr bool
+	}{
+		{"valid", webhookRules{missingSecret: ruleDeny, invalidCert: ruleDeny, expiredCert: ruleWarn, sanCoverage: ruleOff}, false},
+		{"unknown action", webhookRules{missingSecret: "block", invalidCert: ruleDeny, expiredCert: ruleWarn, sanCoverage: ruleOff}, true},
+		{"unset action", webhookRules{missingSecret: ruleDeny, invalidCert: ruleDeny, expiredCert: ruleWarn}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rules.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
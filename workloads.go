@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Set by the injector on every pod with a sidecar
+const sidecarStatusAnnotation = "sidecar.istio.io/status"
+
+// Subset of the Envoy admin /certs response
+type envoyCerts struct {
+	Certificates []struct {
+		CertChain []struct {
+			SubjectAltNames []struct {
+				URI string `json:"uri"`
+			} `json:"subject_alt_names"`
+			ValidFrom      time.Time `json:"valid_from"`
+			ExpirationTime time.Time `json:"expiration_time"`
+		} `json:"cert_chain"`
+	} `json:"certificates"`
+}
+
+type workloadSummary struct {
+	Cluster     string  `json:"cluster,omitempty"`
+	Namespace   string  `json:"namespace"`
+	Sampled     int     `json:"sampled"`
+	Unreachable int     `json:"unreachable"`
+	MinDays     float64 `json:"minDays"`
+	MedianDays  float64 `json:"medianDays"`
+	Expired     int     `json:"expired"`
+
+	// Not rotated: less than --workload-stale-fraction of the lifetime left
+	Stale int `json:"stale"`
+}
+
+// The pods/proxy subresource connects from the API server to the pod IP. Istio
+// binds the Envoy admin API (15000) to localhost, and neither the Envoy
+// Prometheus port (15090) nor the pilot-agent port (15020) serve /certs, so
+// sampling only works where the admin API is exposed on the pod IP, for example
+// through a custom bootstrap. Other pods are counted as unreachable.
+func workloadCertExpiry(kclient *kubernetes.Clientset, pod corev1.Pod, port string, timeout time.Duration) (time.Time, time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+	defer cancel()
+
+	data, err := kclient.CoreV1().Pods(pod.Namespace).ProxyGet("http", pod.Name, port, "certs", nil).DoRaw(ctx)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	return parseEnvoyCerts(data)
+}
+
+// Validity of the workload certificate, the one carrying a SPIFFE identity
+func parseEnvoyCerts(data []byte) (time.Time, time.Time, error) {
+	var certs envoyCerts
+	err := json.Unmarshal(data, &certs)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("unable to parse Envoy certs: %v", err)
+	}
+
+	for _, cert := range certs.Certificates {
+		for _, chain := range cert.CertChain {
+			for _, san := range chain.SubjectAltNames {
+				if strings.HasPrefix(san.URI, "spiffe://") {
+					return chain.ValidFrom, chain.ExpirationTime, nil
+				}
+			}
+		}
+	}
+
+	return time.Time{}, time.Time{}, fmt.Errorf("no workload certificate loaded")
+}
+
+// Up to sample pods that answer, giving up after as many unreachable ones
+func sampleWorkloadCerts(kclient *kubernetes.Clientset, ns string, sample int, port string, timeout time.Duration, staleFraction float64) (*workloadSummary, error) {
+	podList, err := listPods(kclient, ns, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list pods: %w", err)
+	}
+
+	summary := &workloadSummary{Namespace: ns}
+	var days []float64
+	for _, pod := range podList.Items {
+		if summary.Sampled >= sample || summary.Unreachable >= sample {
+			break
+		}
+		if _, ok := pod.Annotations[sidecarStatusAnnotation]; !ok || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+
+		validFrom, expiry, err := workloadCertExpiry(kclient, pod, port, timeout)
+		if err != nil {
+			debugf("Unable to read workload certificate of pod %s in namespace %s: %v", pod.Name, ns, err)
+			summary.Unreachable++
+			continue
+		}
+		summary.Sampled++
+		if workloadCertStale(validFrom, expiry, clock(), staleFraction) {
+			summary.Stale++
+		}
+		if clock().After(expiry) {
+			summary.Expired++
+		}
+		days = append(days, expiry.Sub(clock()).Hours()/24)
+	}
+
+	if summary.Sampled == 0 && summary.Unreachable == 0 {
+		return nil, nil
+	}
+	if len(days) > 0 {
+		sort.Float64s(days)
+		summary.MinDays = days[0]
+		summary.MedianDays = days[len(days)/2]
+		if len(days)%2 == 0 {
+			summary.MedianDays = (days[len(days)/2-1] + days[len(days)/2]) / 2
+		}
+	}
+
+	return summary, nil
+}
+
+// Istio rotates workload certificates at half their lifetime, so one with less
+// than fraction left, expired ones included, missed its rotation
+func workloadCertStale(validFrom, expiry, now time.Time, fraction float64) bool {
+	lifetime := expiry.Sub(validFrom)
+	if fraction <= 0 || lifetime <= 0 {
+		return false
+	}
+
+	return float64(expiry.Sub(now))/float64(lifetime) < fraction
+}
+
+func (s workloadSummary) String() string {
+	if s.Sampled == 0 {
+		return fmt.Sprintf("Workload certificates in namespace %s: none of %d pods answered", s.Namespace, s.Unreachable)
+	}
+
+	return fmt.Sprintf("Workload certificates in namespace %s: %d pods sampled, %d unreachable, min %.1f days, median %.1f days, %d stale, %d expired", s.Namespace, s.Sampled, s.Unreachable, s.MinDays, s.MedianDays, s.Stale, s.Expired)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func envoyCertsJSON(validFrom, expiry time.Time) string {
+	return fmt.Sprintf(`{"certificates":[{"cert_chain":[{"subject_alt_names":[{"dns":"root"}],"valid_from":%q,"expiration_time":%q}]},`+
+		`{"cert_chain":[{"subject_alt_names":[{"uri":"spiffe://cluster.local/ns/apps/sa/web"}],"valid_from":%q,"expiration_time":%q}]}]}`,
+		validFrom.AddDate(-1, 0, 0).Format(time.RFC3339), expiry.AddDate(10, 0, 0).Format(time.RFC3339),
+		validFrom.Format(time.RFC3339), expiry.Format(time.RFC3339))
+}
+
+func TestParseEnvoyCerts(t *testing.T) {
+	validFrom := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	expiry := validFrom.Add(24 * time.Hour)
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "workload certificate", data: envoyCertsJSON(validFrom, expiry)},
+		{name: "no spiffe certificate", data: `{"certificates":[{"cert_chain":[{"subject_alt_names":[{"dns":"example.com"}]}]}]}`, wantErr: true},
+		{name: "empty", data: `{}`, wantErr: true},
+		{name: "not json", data: `<html>`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, err := parseEnvoyCerts([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseEnvoyCerts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (!from.Equal(validFrom) || !to.Equal(expiry)) {
+				t.Errorf("parseEnvoyCerts() = %s, %s, want %s, %s", from, to, validFrom, expiry)
+			}
+		})
+	}
+}
+
+func TestWorkloadCertStale(t *testing.T) {
+	validFrom := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	expiry := validFrom.Add(24 * time.Hour)
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		fraction float64
+		want     bool
+	}{
+		{"fresh", validFrom.Add(time.Hour), 0.25, false},
+		{"due for rotation", validFrom.Add(13 * time.Hour), 0.25, false},
+		{"missed rotation", validFrom.Add(20 * time.Hour), 0.25, true},
+		{"expired", expiry.Add(time.Hour), 0.25, true},
+		{"disabled", validFrom.Add(20 * time.Hour), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := workloadCertStale(validFrom, expiry, tt.now, tt.fraction); got != tt.want {
+				t.Errorf("workloadCertStale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSampleWorkloadCerts(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock = func() time.Time { return now }
+	defer func() { clock = time.Now }()
+
+	pod := func(name string, sidecar bool) corev1.Pod {
+		p := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps"}, Status: corev1.PodStatus{Phase: corev1.PodRunning}}
+		if sidecar {
+			p.Annotations = map[string]string{sidecarStatusAnnotation: "{}"}
+		}
+		return p
+	}
+
+	tests := []struct {
+		name            string
+		pods            []corev1.Pod
+		answers         map[string]time.Time // expiry per answering pod, valid for a day
+		sample          int
+		wantSampled     int
+		wantUnreachable int
+		wantStale       int
+		wantNil         bool
+	}{
+		{
+			name:        "all answer",
+			pods:        []corev1.Pod{pod("a", true), pod("b", true)},
+			answers:     map[string]time.Time{"a": now.Add(20 * time.Hour), "b": now.Add(2 * time.Hour)},
+			sample:      5,
+			wantSampled: 2,
+			wantStale:   1,
+		},
+		{
+			name:            "unreachable pods are not sampled",
+			pods:            []corev1.Pod{pod("a", true), pod("b", true), pod("c", true)},
+			answers:         map[string]time.Time{"b": now.Add(20 * time.Hour), "c": now.Add(20 * time.Hour)},
+			sample:          2,
+			wantSampled:     2,
+			wantUnreachable: 1,
+		},
+		{
+			name:            "gives up after as many unreachable",
+			pods:            []corev1.Pod{pod("a", true), pod("b", true), pod("c", true)},
+			answers:         map[string]time.Time{"c": now.Add(20 * time.Hour)},
+			sample:          2,
+			wantUnreachable: 2,
+		},
+		{
+			name:    "no sidecars",
+			pods:    []corev1.Pod{pod("a", false)},
+			sample:  2,
+			wantNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if r.URL.Path == "/api/v1/namespaces/apps/pods" {
+					json.NewEncoder(w).Encode(corev1.PodList{TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"}, Items: tt.pods})
+					return
+				}
+				// /api/v1/namespaces/apps/pods/http:<name>:15000/proxy/certs
+				name := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/apps/pods/"), ":")
+				expiry, ok := tt.answers[name[1]]
+				if !ok {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				fmt.Fprint(w, envoyCertsJSON(expiry.Add(-24*time.Hour), expiry))
+			}))
+			defer srv.Close()
+			kclient, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			summary, err := sampleWorkloadCerts(kclient, "apps", tt.sample, "15000", time.Second, 0.25)
+			if err != nil {
+				t.Fatalf("sampleWorkloadCerts() error = %v", err)
+			}
+			if tt.wantNil {
+				if summary != nil {
+					t.Errorf("sampleWorkloadCerts() = %+v, want nil", summary)
+				}
+				return
+			}
+			if summary.Sampled != tt.wantSampled || summary.Unreachable != tt.wantUnreachable || summary.Stale != tt.wantStale {
+				t.Errorf("sampled, unreachable, stale = %d, %d, %d, want %d, %d, %d", summary.Sampled, summary.Unreachable, summary.Stale, tt.wantSampled, tt.wantUnreachable, tt.wantStale)
+			}
+		})
+	}
+}